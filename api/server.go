@@ -8,8 +8,11 @@ import (
 
 	"github.com/extremtechniker/godns/cache"
 	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/dns"
+	"github.com/extremtechniker/godns/forward"
 	"github.com/extremtechniker/godns/logger"
 	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/notify"
 	"github.com/extremtechniker/godns/util"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
@@ -18,6 +21,8 @@ import (
 type Server struct {
 	Addr string
 	Ctx  context.Context
+	// DoH, when set, serves DNS-over-HTTPS at dns.DoHPath without authentication.
+	DoH http.Handler
 }
 
 var jwtSecret = []byte(util.GetJwtSecret()) // from env in production
@@ -27,9 +32,15 @@ func NewServer(addr string, ctx context.Context) *Server {
 }
 
 func (s *Server) Run() error {
-	r := mux.NewRouter()
+	root := mux.NewRouter()
 
-	// Middleware applied to all routes
+	// DNS-over-HTTPS is public, like the DNS listeners
+	if s.DoH != nil {
+		root.Handle(dns.DoHPath, s.DoH).Methods("GET", "POST")
+	}
+
+	// Middleware applied to all management routes
+	r := root.PathPrefix("/").Subrouter()
 	r.Use(s.jwtMiddleware)
 
 	// Record CRUD
@@ -38,12 +49,57 @@ func (s *Server) Run() error {
 	r.HandleFunc("/records/{domain}/{qtype}", s.UpdateRecordTTL).Methods("PUT")
 	r.HandleFunc("/records/{domain}/{qtype}", s.DeleteRecord).Methods("DELETE")
 
+	// Zone management
+	r.HandleFunc("/zones", s.CreateZone).Methods("POST")
+	r.HandleFunc("/zones", s.ListZones).Methods("GET")
+	r.HandleFunc("/zones/{zone}", s.DeleteZone).Methods("DELETE")
+
+	// Query ACLs of the server and of each zone
+	r.HandleFunc("/query-acls", s.ListQueryACLs).Methods("GET")
+	for _, path := range []string{"/query-acl", "/zones/{zone}/query-acl"} {
+		r.HandleFunc(path, s.SetQueryACL).Methods("PUT")
+		r.HandleFunc(path, s.GetQueryACL).Methods("GET")
+		r.HandleFunc(path, s.DeleteQueryACL).Methods("DELETE")
+	}
+
+	// TSIG key management
+	r.HandleFunc("/tsig-keys", s.CreateTsigKey).Methods("POST")
+	r.HandleFunc("/tsig-keys", s.ListTsigKeys).Methods("GET")
+	r.HandleFunc("/tsig-keys/{name}", s.DeleteTsigKey).Methods("DELETE")
+
+	// Conditional forwarding rules
+	r.HandleFunc("/forward-rules", s.CreateForwardRule).Methods("POST")
+	r.HandleFunc("/forward-rules", s.ListForwardRules).Methods("GET")
+	r.HandleFunc("/forward-rules/{suffix}", s.DeleteForwardRule).Methods("DELETE")
+
+	// Answer selection policies
+	r.HandleFunc("/answer-policies", s.CreateAnswerPolicy).Methods("POST")
+	r.HandleFunc("/answer-policies", s.ListAnswerPolicies).Methods("GET")
+	r.HandleFunc("/answer-policies/{domain}", s.DeleteAnswerPolicy).Methods("DELETE")
+
+	// Health check states
+	r.HandleFunc("/health-checks", s.ListHealthChecks).Methods("GET")
+
+	// Split-horizon views
+	r.HandleFunc("/views", s.CreateView).Methods("POST")
+	r.HandleFunc("/views", s.ListViews).Methods("GET")
+	r.HandleFunc("/views/{name}", s.DeleteView).Methods("DELETE")
+
+	// Response policies: blocklists, RPZ zones and local overrides
+	r.HandleFunc("/rpz/sources", s.CreateRPZSource).Methods("POST")
+	r.HandleFunc("/rpz/sources", s.ListRPZSources).Methods("GET")
+	r.HandleFunc("/rpz/sources/{name}", s.DeleteRPZSource).Methods("DELETE")
+	r.HandleFunc("/rpz/overrides", s.CreateRPZOverride).Methods("POST")
+	r.HandleFunc("/rpz/overrides", s.ListRPZOverrides).Methods("GET")
+	r.HandleFunc("/rpz/overrides/{name}", s.DeleteRPZOverride).Methods("DELETE")
+	r.HandleFunc("/rpz/hits", s.ListRPZHits).Methods("GET")
+
 	// Cache management
 	r.HandleFunc("/cache/{domain}/{qtype}", s.AddToCache).Methods("POST")
 	r.HandleFunc("/cache/{domain}/{qtype}", s.RemoveFromCache).Methods("DELETE")
 
 	logger.Logger.Infof("HTTP API listening on %s", s.Addr)
-	return http.ListenAndServe(s.Addr, r)
+	return http.ListenAndServe(s.Addr, root)
 }
 
 // ---------------- JWT Middleware ----------------
@@ -80,19 +136,29 @@ func (s *Server) CreateRecord(w http.ResponseWriter, r *http.Request) {
 		rec.TTL = 300
 	}
 
+	rec.QType = strings.ToUpper(rec.QType)
+	if err := rec.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.Domain = strings.ToLower(strings.TrimSuffix(rec.Domain, "."))
+	rec.View, rec.Region = strings.ToLower(rec.View), strings.ToLower(rec.Region)
+	if !s.viewExists(w, rec.View) {
+		return
+	}
+
 	if err := db.AddRecord(s.Ctx, rec); err != nil {
 		http.Error(w, "failed to add record", http.StatusInternalServerError)
 		return
 	}
 
-	// Update cache only if exists
-	key := cache.CacheKey(rec.Domain, rec.QType)
-	if exists, _ := cache.Rdb.Exists(s.Ctx, key).Result(); exists > 0 {
-		if err := cache.CacheRecord(s.Ctx, rec.Domain, rec.QType, []model.Record{rec}); err != nil {
-			logger.Logger.Errorf("failed to update cache: %v", err)
-		}
+	// Drop the cached set so it is rebuilt with the new record
+	if err := cache.Invalidate(s.Ctx, []model.Record{rec}); err != nil {
+		logger.Logger.Errorf("failed to update cache: %v", err)
 	}
 
+	go s.notifySecondaries(rec.Domain)
+
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -107,7 +173,7 @@ func (s *Server) ListRecords(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) UpdateRecordTTL(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	domain := vars["domain"]
+	domain := strings.ToLower(strings.TrimSuffix(vars["domain"], "."))
 	qtype := vars["qtype"]
 
 	var input struct {
@@ -118,7 +184,16 @@ func (s *Server) UpdateRecordTTL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	records, err := db.FetchRecords(s.Ctx, domain, qtype)
+	// Only the records of the given view and region, not the untagged ones they fall back to
+	view := strings.ToLower(r.URL.Query().Get("view"))
+	region := strings.ToLower(r.URL.Query().Get("region"))
+	all, err := db.FetchRecords(s.Ctx, view, domain, qtype)
+	var records []model.Record
+	for _, rec := range all {
+		if rec.View == view && rec.Region == region {
+			records = append(records, rec)
+		}
+	}
 	if err != nil || len(records) == 0 {
 		http.Error(w, "record not found", http.StatusNotFound)
 		return
@@ -132,44 +207,464 @@ func (s *Server) UpdateRecordTTL(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	key := cache.CacheKey(domain, qtype)
-	if exists, _ := cache.Rdb.Exists(s.Ctx, key).Result(); exists > 0 {
-		if err := cache.CacheRecord(s.Ctx, domain, qtype, records); err != nil {
-			logger.Logger.Errorf("failed to update cache: %v", err)
-		}
+	if err := cache.Invalidate(s.Ctx, records); err != nil {
+		logger.Logger.Errorf("failed to update cache: %v", err)
 	}
 
+	go s.notifySecondaries(domain)
+
 	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) DeleteRecord(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	domain := vars["domain"]
+	domain := strings.ToLower(strings.TrimSuffix(vars["domain"], "."))
 	qtype := vars["qtype"]
 
-	_, err := db.PgPool.Exec(s.Ctx, "DELETE FROM dns_records WHERE domain=$1 AND qtype=$2", domain, qtype)
+	view := strings.ToLower(r.URL.Query().Get("view"))
+	region := strings.ToLower(r.URL.Query().Get("region"))
+
+	if err := db.DeleteRecords(s.Ctx, domain, qtype, view, region); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	if err := cache.Invalidate(s.Ctx, []model.Record{{Domain: domain, QType: qtype, View: view}}); err != nil {
+		logger.Logger.Errorf("failed to update cache: %v", err)
+	}
+	go s.notifySecondaries(domain)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// notifySecondaries tells the secondaries of the zone owning domain about a change.
+func (s *Server) notifySecondaries(domain string) {
+	if err := notify.Zone(s.Ctx, domain); err != nil {
+		logger.Logger.Errorf("failed to notify secondaries: %v", err)
+	}
+}
+
+func (s *Server) CreateZone(w http.ResponseWriter, r *http.Request) {
+	var zone model.Zone
+	if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if zone.Name == "" {
+		http.Error(w, "zone name is required", http.StatusBadRequest)
+		return
+	}
+
+	zone.SetDefaults()
+	if err := db.AddZone(s.Ctx, zone); err != nil {
+		http.Error(w, "failed to add zone", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) ListZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := db.FetchZones(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch zones", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(zones)
+}
+
+func (s *Server) DeleteZone(w http.ResponseWriter, r *http.Request) {
+	zone := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["zone"], "."))
+
+	if err := db.DeleteZone(s.Ctx, zone); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// queryACLZone returns the zone of a query ACL route, "" for the server ACL,
+// answering the request with an error when the zone does not exist.
+func (s *Server) queryACLZone(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, ok := mux.Vars(r)["zone"]
+	if !ok {
+		return "", true
+	}
+	zone, err := db.GetZone(s.Ctx, name)
+	if err != nil {
+		http.Error(w, "failed to fetch zone", http.StatusInternalServerError)
+		return "", false
+	}
+	if zone == nil {
+		http.Error(w, "zone not found", http.StatusNotFound)
+		return "", false
+	}
+	return zone.Name, true
+}
+
+func (s *Server) SetQueryACL(w http.ResponseWriter, r *http.Request) {
+	zone, ok := s.queryACLZone(w, r)
+	if !ok {
+		return
+	}
+	var acl model.QueryACL
+	if err := json.NewDecoder(r.Body).Decode(&acl); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	acl.Zone = zone
+	if err := acl.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.SetQueryACL(s.Ctx, acl); err != nil {
+		http.Error(w, "failed to set query acl", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(acl)
+}
+
+func (s *Server) GetQueryACL(w http.ResponseWriter, r *http.Request) {
+	zone, ok := s.queryACLZone(w, r)
+	if !ok {
+		return
+	}
+	acl, err := db.GetQueryACL(s.Ctx, zone)
+	if err != nil {
+		http.Error(w, "failed to fetch query acl", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(acl)
+}
+
+func (s *Server) DeleteQueryACL(w http.ResponseWriter, r *http.Request) {
+	zone, ok := s.queryACLZone(w, r)
+	if !ok {
+		return
+	}
+	if err := db.DeleteQueryACL(s.Ctx, zone); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) ListQueryACLs(w http.ResponseWriter, r *http.Request) {
+	acls, err := db.FetchQueryACLs(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch query acls", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(acls)
+}
+
+func (s *Server) CreateTsigKey(w http.ResponseWriter, r *http.Request) {
+	var key model.TsigKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if key.Name == "" {
+		http.Error(w, "key name is required", http.StatusBadRequest)
+		return
+	}
+	if err := key.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddTsigKey(s.Ctx, key); err != nil {
+		http.Error(w, "failed to add tsig key", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the key so a generated secret can be handed to the secondary
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (s *Server) ListTsigKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := db.FetchTsigKeys(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch tsig keys", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (s *Server) DeleteTsigKey(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["name"], "."))
+
+	if err := db.DeleteTsigKey(s.Ctx, name); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) CreateForwardRule(w http.ResponseWriter, r *http.Request) {
+	rule := model.ForwardRule{Cache: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := rule.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := forward.New(rule.Specs()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddForwardRule(s.Ctx, rule); err != nil {
+		http.Error(w, "failed to add forward rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *Server) ListForwardRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := db.FetchForwardRules(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch forward rules", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *Server) DeleteForwardRule(w http.ResponseWriter, r *http.Request) {
+	suffix := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["suffix"], "."))
+
+	if err := db.DeleteForwardRule(s.Ctx, suffix); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) CreateAnswerPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy model.AnswerPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := policy.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddAnswerPolicy(s.Ctx, policy); err != nil {
+		http.Error(w, "failed to add answer policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (s *Server) ListAnswerPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := db.FetchAnswerPolicies(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch answer policies", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(policies)
+}
+
+func (s *Server) DeleteAnswerPolicy(w http.ResponseWriter, r *http.Request) {
+	domain := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["domain"], "."))
+
+	if err := db.DeleteAnswerPolicy(s.Ctx, domain); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) ListHealthChecks(w http.ResponseWriter, r *http.Request) {
+	status, err := db.FetchHealthStatus(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch health checks", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) CreateView(w http.ResponseWriter, r *http.Request) {
+	var view model.View
+	if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := view.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddView(s.Ctx, view); err != nil {
+		http.Error(w, "failed to add view", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+func (s *Server) ListViews(w http.ResponseWriter, r *http.Request) {
+	views, err := db.FetchViews(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch views", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(views)
+}
+
+func (s *Server) DeleteView(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(mux.Vars(r)["name"])
+
+	deleted, err := db.DeleteView(s.Ctx, name)
 	if err != nil {
 		http.Error(w, "failed to delete", http.StatusInternalServerError)
 		return
 	}
+	if err := cache.Invalidate(s.Ctx, deleted); err != nil {
+		logger.Logger.Errorf("failed to update cache: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// viewExists reports whether records can be tagged with view, answering the
+// request with an error when they cannot. The empty view always exists.
+func (s *Server) viewExists(w http.ResponseWriter, view string) bool {
+	if view == "" {
+		return true
+	}
+	v, err := db.GetView(s.Ctx, view)
+	if err != nil {
+		http.Error(w, "failed to fetch view", http.StatusInternalServerError)
+		return false
+	}
+	if v == nil {
+		http.Error(w, "unknown view "+view, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (s *Server) CreateRPZSource(w http.ResponseWriter, r *http.Request) {
+	var src model.RPZSource
+	if err := json.NewDecoder(r.Body).Decode(&src); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := src.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddRPZSource(s.Ctx, src); err != nil {
+		http.Error(w, "failed to add rpz source", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(src)
+}
+
+func (s *Server) ListRPZSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := db.FetchRPZSources(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch rpz sources", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(sources)
+}
+
+func (s *Server) DeleteRPZSource(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["name"], "."))
+
+	if err := db.DeleteRPZSource(s.Ctx, name); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
 
-	cache.Rdb.Del(s.Ctx, cache.CacheKey(domain, qtype))
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) CreateRPZOverride(w http.ResponseWriter, r *http.Request) {
+	var override model.RPZOverride
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := override.SetDefaults(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddRPZOverride(s.Ctx, override); err != nil {
+		http.Error(w, "failed to add rpz override", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(override)
+}
+
+func (s *Server) ListRPZOverrides(w http.ResponseWriter, r *http.Request) {
+	overrides, err := db.FetchRPZOverrides(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch rpz overrides", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(overrides)
+}
+
+func (s *Server) DeleteRPZOverride(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSuffix(mux.Vars(r)["name"], "."))
+
+	if err := db.DeleteRPZOverride(s.Ctx, name); err != nil {
+		http.Error(w, "failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) ListRPZHits(w http.ResponseWriter, r *http.Request) {
+	hits, err := db.FetchRPZHits(s.Ctx)
+	if err != nil {
+		http.Error(w, "failed to fetch rpz hits", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(hits)
+}
+
 func (s *Server) AddToCache(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	domain := vars["domain"]
+	domain := strings.ToLower(strings.TrimSuffix(vars["domain"], "."))
 	qtype := vars["qtype"]
+	view := strings.ToLower(r.URL.Query().Get("view"))
 
-	recs, err := db.FetchRecords(s.Ctx, domain, qtype)
+	recs, err := db.FetchRecords(s.Ctx, view, domain, qtype)
 	if err != nil || len(recs) == 0 {
 		http.Error(w, "record not found", http.StatusNotFound)
 		return
 	}
 
-	if err := cache.CacheRecord(s.Ctx, domain, qtype, recs); err != nil {
-		http.Error(w, "failed to cache", http.StatusInternalServerError)
+	if err := cache.CacheRecord(s.Ctx, view, domain, qtype, recs); err != nil {
+		http.Error(w, "failed to cache: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -178,14 +673,21 @@ func (s *Server) AddToCache(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) RemoveFromCache(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	domain := vars["domain"]
+	domain := strings.ToLower(strings.TrimSuffix(vars["domain"], "."))
 	qtype := vars["qtype"]
+	view := strings.ToLower(r.URL.Query().Get("view"))
 
-	cache.Rdb.Del(s.Ctx, cache.CacheKey(domain, qtype))
+	// Drops the set cached for every client location as well
+	if err := cache.Invalidate(s.Ctx, []model.Record{{Domain: domain, QType: qtype, View: view}}); err != nil {
+		http.Error(w, "failed to remove from cache", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func StartServer(ctx context.Context) error {
+// StartServer runs the HTTP API, serving DNS-over-HTTPS through doh when it is not nil.
+func StartServer(ctx context.Context, doh http.Handler) error {
 	srv := NewServer(util.MustGetenv("HTTP_SERVE", ":8080"), ctx)
+	srv.DoH = doh
 	return srv.Run()
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"strconv"
 	"strings"
 	"time"
@@ -28,15 +29,186 @@ func InitRedis(ctx context.Context) error {
 	return nil
 }
 
-func CacheKey(domain, qtype string) string {
-	return fmt.Sprintf("dns:record:%s:%s", strings.ToLower(domain), strings.ToUpper(qtype))
+// viewsKey is the Redis set of views that have record sets in the cache.
+const viewsKey = "dns:views"
+
+// CacheKey returns the Redis key of a record set as seen by clients of view
+// ("" for clients outside any view). Answers synthesised from a wildcard are
+// cached under the wildcard owner (e.g. "*.example.com") so every matching
+// child name shares a single entry.
+func CacheKey(view, domain, qtype string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	key := fmt.Sprintf("dns:record:%s:%s", strings.ToLower(domain), strings.ToUpper(qtype))
+	if view != "" {
+		key = "dns:view:" + view + ":" + strings.TrimPrefix(key, "dns:")
+	}
+	return key
+}
+
+// GeoKey returns the Redis key of a record set holding regional records, as
+// selected for clients of view at location (see geo.Location.Key).
+func GeoKey(view, location, domain, qtype string) string {
+	return CacheKey(view, domain, qtype) + ":geo:" + location
+}
+
+// geoIndexKey returns the Redis set of the GeoKeys cached for a record set, so
+// they can be dropped without knowing every location clients came from.
+func geoIndexKey(view, domain, qtype string) string {
+	return CacheKey(view, domain, qtype) + ":locations"
+}
+
+// AliasKey returns the Redis key holding the resolved addresses of an ALIAS target.
+func AliasKey(target, qtype string) string {
+	target = strings.TrimSuffix(target, ".")
+	return fmt.Sprintf("dns:alias:%s:%s", strings.ToLower(target), strings.ToUpper(qtype))
+}
+
+// ForwardKey returns the Redis key of a response from a set of upstream
+// resolvers, so answers of different upstreams never mix. Answers with and
+// without DNSSEC records are cached separately, as are answers to queries with
+// checking disabled, which the upstreams did not validate.
+func ForwardKey(upstreams, name, qtype string, dnssec, checkingDisabled bool) string {
+	h := fnv.New32a()
+	h.Write([]byte(upstreams))
+	name = strings.TrimSuffix(name, ".")
+	key := fmt.Sprintf("dns:forward:%08x:%s:%s", h.Sum32(), strings.ToLower(name), strings.ToUpper(qtype))
+	if dnssec {
+		key += ":do"
+	}
+	if checkingDisabled {
+		key += ":cd"
+	}
+	return key
+}
+
+// HealthKey returns the Redis key holding the state of a health-checked endpoint.
+func HealthKey(target string) string {
+	return "dns:health:" + target
+}
+
+// SignatureKey returns the Redis key of a cached RRSIG. The digest identifies the
+// exact RRset content and signing key so changed records never reuse a stale signature.
+func SignatureKey(owner, qtype, digest string) string {
+	owner = strings.TrimSuffix(owner, ".")
+	return fmt.Sprintf("dns:rrsig:%s:%s:%s", strings.ToLower(owner), strings.ToUpper(qtype), digest)
+}
+
+// WildcardName returns the wildcard owner directly below the closest encloser.
+func WildcardName(encloser string) string {
+	return "*." + strings.TrimSuffix(encloser, ".")
 }
 
 // CacheRecord is used by CLI and metrics logic
-func CacheRecord(ctx context.Context, domain, qtype string, records []model.Record) error {
+func CacheRecord(ctx context.Context, view, domain, qtype string, records []model.Record) error {
+	if len(records) == 0 {
+		return fmt.Errorf("no records to cache")
+	}
+	for _, r := range records {
+		if r.Region != "" {
+			return fmt.Errorf("%s %s has regional records, they are cached per client location", domain, qtype)
+		}
+	}
+	if view != "" {
+		if err := Rdb.SAdd(ctx, viewsKey, view).Err(); err != nil {
+			return err
+		}
+	}
+	b, _ := json.Marshal(records)
+	return Rdb.Set(ctx, CacheKey(view, domain, qtype), b, time.Hour).Err()
+}
+
+// CacheGeoRecord caches the records selected from a set holding regional
+// records for clients of view at location.
+func CacheGeoRecord(ctx context.Context, view, location, domain, qtype string, records []model.Record) error {
 	if len(records) == 0 {
 		return fmt.Errorf("no records to cache")
 	}
+	pipe := Rdb.TxPipeline()
+	if view != "" {
+		pipe.SAdd(ctx, viewsKey, view)
+	}
+	key, index := GeoKey(view, location, domain, qtype), geoIndexKey(view, domain, qtype)
 	b, _ := json.Marshal(records)
-	return Rdb.Set(ctx, CacheKey(domain, qtype), b, time.Hour).Err()
+	pipe.Set(ctx, key, b, time.Hour)
+	pipe.SAdd(ctx, index, key)
+	pipe.Expire(ctx, index, time.Hour)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Invalidate drops the cached record sets touched by the given records, for
+// every client location. Records outside any view are also served to views
+// without their own set, so their change drops the set cached for every view.
+func Invalidate(ctx context.Context, records []model.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	views, err := Rdb.SMembers(ctx, viewsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	// The affected record sets with the sets indexing their GeoKeys
+	type recordSet struct {
+		key, index string
+		geo        *redis.StringSliceCmd
+	}
+	seen := map[string]bool{}
+	var sets []*recordSet
+	pipe := Rdb.Pipeline()
+	for _, r := range records {
+		affected := []string{r.View}
+		if r.View == "" {
+			affected = append(affected, views...)
+		}
+		for _, v := range affected {
+			key := CacheKey(v, r.Domain, r.QType)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			index := geoIndexKey(v, r.Domain, r.QType)
+			sets = append(sets, &recordSet{key: key, index: index, geo: pipe.SMembers(ctx, index)})
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	pipe = Rdb.Pipeline()
+	for _, set := range sets {
+		pipe.Del(ctx, append([]string{set.key, set.index}, set.geo.Val()...)...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// SetHealth stores the state of checked endpoints for ttl. Endpoints whose state
+// expired, because no daemon checks them anymore, are treated as healthy.
+func SetHealth(ctx context.Context, states map[string]bool, ttl time.Duration) error {
+	pipe := Rdb.Pipeline()
+	for target, healthy := range states {
+		pipe.Set(ctx, HealthKey(target), healthy, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Unhealthy returns the targets among the given ones that are known to be unhealthy.
+func Unhealthy(ctx context.Context, targets []string) (map[string]bool, error) {
+	keys := make([]string, len(targets))
+	for i, t := range targets {
+		keys[i] = HealthKey(t)
+	}
+	vals, err := Rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for i, v := range vals {
+		if v == "0" {
+			out[targets[i]] = true
+		}
+	}
+	return out, nil
 }
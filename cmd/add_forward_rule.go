@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/forward"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func AddForwardRuleCommand() *cobra.Command {
+	var rule model.ForwardRule
+
+	cmd := &cobra.Command{
+		Use:   "add-forward-rule <suffix>",
+		Short: "Forward queries for a domain suffix to dedicated upstream resolvers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			rule.Suffix = args[0]
+			if err := rule.SetDefaults(); err != nil {
+				return err
+			}
+			if _, err := forward.New(rule.Specs()); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if err := db.AddForwardRule(ctx, rule); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("Forward rule added: %s -> %v", rule.Suffix, rule.Specs())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&rule.Upstreams, "upstream", nil, "Upstream resolver host[:port], repeatable")
+	cmd.Flags().StringVar(&rule.Protocol, "protocol", "udp", "Protocol for upstreams without a scheme (udp, tcp, tls)")
+	cmd.Flags().BoolVar(&rule.Cache, "cache", true, "Cache upstream answers in Redis")
+	return cmd
+}
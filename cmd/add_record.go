@@ -8,10 +8,15 @@ import (
 	"github.com/extremtechniker/godns/db"
 	"github.com/extremtechniker/godns/logger"
 	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/notify"
 	"github.com/spf13/cobra"
 )
 
 func AddRecordCommand() *cobra.Command {
+	var view, region, check string
+	var weight int
+	var backup bool
+
 	cmd := &cobra.Command{
 		Use:   "add-record <domain> <type> <value> [ttl]",
 		Short: "Add a DNS record to Postgres",
@@ -23,7 +28,7 @@ func AddRecordCommand() *cobra.Command {
 				return err
 			}
 
-			domain := args[0]
+			domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
 			qtype := strings.ToUpper(args[1])
 			value := args[2]
 			ttl := 300
@@ -31,14 +36,47 @@ func AddRecordCommand() *cobra.Command {
 				fmt.Sscanf(args[3], "%d", &ttl)
 			}
 
-			rec := model.Record{Domain: domain, QType: qtype, TTL: ttl, Value: value}
+			rec := model.Record{
+				Domain:      domain,
+				QType:       qtype,
+				TTL:         ttl,
+				Value:       value,
+				View:        strings.ToLower(view),
+				Region:      strings.ToLower(region),
+				Weight:      weight,
+				HealthCheck: check,
+				Backup:      backup,
+			}
+			if err := rec.Validate(); err != nil {
+				return fmt.Errorf("invalid record: %w", err)
+			}
+			if rec.View != "" {
+				v, err := db.GetView(ctx, rec.View)
+				if err != nil {
+					return err
+				}
+				if v == nil {
+					return fmt.Errorf("unknown view %s", rec.View)
+				}
+			}
+
 			if err := db.AddRecord(ctx, rec); err != nil {
 				return err
 			}
 
 			logger.Logger.Infof("Record added: %s %s %s", domain, qtype, value)
+
+			if err := notify.Zone(ctx, domain); err != nil {
+				logger.Logger.Errorf("failed to notify secondaries: %v", err)
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&view, "view", "", "Only serve the record to clients of this view")
+	cmd.Flags().IntVar(&weight, "weight", 1, "Relative share of answers under the name's answer policy")
+	cmd.Flags().StringVar(&check, "health-check", "", "Only serve the record while this check passes (tcp://:443, http://:80/health)")
+	cmd.Flags().BoolVar(&backup, "backup", false, "Only serve the record once all other records of the set are unhealthy")
+	cmd.Flags().StringVar(&region, "region", "", "Only serve the record to clients in this GeoDNS region (e.g. country:de)")
 	return cmd
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/rpz"
+	"github.com/spf13/cobra"
+)
+
+func AddRPZOverrideCommand() *cobra.Command {
+	var override model.RPZOverride
+
+	cmd := &cobra.Command{
+		Use:   "add-rpz-override <name>",
+		Short: "Set a local response policy for a name, taking precedence over every RPZ source",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			override.Name = args[0]
+			if err := override.SetDefaults(); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if err := db.AddRPZOverride(ctx, override); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("RPZ override added: %s %s", override.Name, override.Policy)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&override.Policy, "policy", string(rpz.NXDomain), "Policy (nxdomain, nodata, redirect, passthru)")
+	cmd.Flags().StringSliceVar(&override.Sinkhole, "sinkhole", nil, "Address served by the redirect policy, repeatable")
+	return cmd
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func AddRPZSourceCommand() *cobra.Command {
+	var src model.RPZSource
+
+	cmd := &cobra.Command{
+		Use:   "add-rpz-source <name>",
+		Short: "Add a blocklist or response policy zone applied to queries before they are resolved",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			src.Name = args[0]
+			if err := src.SetDefaults(); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if err := db.AddRPZSource(ctx, src); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("RPZ source added: %s (%s)", src.Name, src.Format)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&src.Format, "format", model.RPZFormatHosts, "Source format (hosts, domains, rpz)")
+	cmd.Flags().StringVar(&src.File, "file", "", "Local file the source is read from")
+	cmd.Flags().StringVar(&src.Primary, "primary", "", "Primary (host[:port]) an RPZ zone is transferred from via AXFR")
+	cmd.Flags().StringVar(&src.Key, "key", "", "TSIG key to sign transfers from the primary with")
+	cmd.Flags().StringVar(&src.Policy, "policy", "", "Policy for every name (nxdomain, nodata, redirect, passthru)")
+	cmd.Flags().StringSliceVar(&src.Sinkhole, "sinkhole", nil, "Address served by the redirect policy, repeatable")
+	cmd.Flags().IntVar(&src.Refresh, "refresh", 3600, "Reload interval in seconds")
+	cmd.Flags().IntVar(&src.Priority, "priority", 0, "Match order, lower first")
+	return cmd
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func AddTsigKeyCommand() *cobra.Command {
+	var key model.TsigKey
+
+	cmd := &cobra.Command{
+		Use:   "add-tsig-key <name>",
+		Short: "Store a TSIG key in Postgres, generating a secret if none is given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+
+			key.Name = args[0]
+			if err := key.SetDefaults(); err != nil {
+				return err
+			}
+			if err := db.AddTsigKey(ctx, key); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("TSIG key added: %s (%s)", key.Name, key.Algorithm)
+			fmt.Println(key.Secret)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&key.Algorithm, "algorithm", "hmac-sha256", "TSIG algorithm (hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384, hmac-sha512)")
+	cmd.Flags().StringVar(&key.Secret, "secret", "", "Base64 encoded secret (generated when empty)")
+	return cmd
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func AddViewCommand() *cobra.Command {
+	var view model.View
+
+	cmd := &cobra.Command{
+		Use:   "add-view <name>",
+		Short: "Add a split-horizon view selecting records by client subnet, TSIG key or listener",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			view.Name = args[0]
+			if err := view.SetDefaults(); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if err := db.AddView(ctx, view); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("View added: %s (networks %v)", view.Name, view.Networks)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&view.Networks, "network", nil, "Client CIDR of the view, repeatable")
+	cmd.Flags().StringVar(&view.TsigKey, "tsig-key", "", "Require queries to be signed with this TSIG key")
+	cmd.Flags().StringSliceVar(&view.Listen, "listen", nil, "Local address queries must arrive on, repeatable")
+	cmd.Flags().IntVar(&view.Priority, "priority", 0, "Match order, lower first")
+	return cmd
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func AddZoneCommand() *cobra.Command {
+	var zone model.Zone
+
+	cmd := &cobra.Command{
+		Use:   "add-zone <zone>",
+		Short: "Create an authoritative zone in Postgres",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+
+			zone.Name = args[0]
+			zone.SetDefaults()
+			if err := db.AddZone(ctx, zone); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("Zone added: %s (serial %d)", zone.Name, zone.Serial)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&zone.Ns, "ns", "", "Primary nameserver for the SOA (default ns1.<zone>)")
+	cmd.Flags().StringVar(&zone.Mbox, "mbox", "", "Hostmaster mailbox for the SOA (default hostmaster.<zone>)")
+	cmd.Flags().IntVar(&zone.Refresh, "refresh", 0, "SOA refresh interval in seconds (default 3600)")
+	cmd.Flags().IntVar(&zone.Retry, "retry", 0, "SOA retry interval in seconds (default 600)")
+	cmd.Flags().IntVar(&zone.Expire, "expire", 0, "SOA expire time in seconds (default 604800)")
+	cmd.Flags().IntVar(&zone.Minimum, "minimum", 0, "SOA minimum / negative caching TTL in seconds (default 300)")
+	cmd.Flags().IntVar(&zone.TTL, "ttl", 0, "TTL of the SOA record in seconds (default 3600)")
+	cmd.Flags().StringSliceVar(&zone.TransferACL, "transfer-acl", nil, "Networks allowed to AXFR/IXFR the zone (e.g. 192.0.2.0/24)")
+	cmd.Flags().StringVar(&zone.TransferKey, "transfer-key", "", "TSIG key required for AXFR/IXFR of the zone")
+	cmd.Flags().StringSliceVar(&zone.AlsoNotify, "also-notify", nil, "Secondaries (host[:port]) to send NOTIFY to when records change")
+	cmd.Flags().StringVar(&zone.Primary, "primary", "", "Make this a secondary zone transferred from the given primary (host[:port])")
+	cmd.Flags().StringVar(&zone.PrimaryKey, "primary-key", "", "TSIG key used for transfers from the primary")
+	cmd.Flags().StringVar(&zone.UpdateKey, "update-key", "", "TSIG key that may send dynamic updates (RFC 2136) for the zone")
+	return cmd
+}
@@ -19,7 +19,7 @@ func ApiCommand() *cobra.Command {
 				return err
 			}
 
-			return api.StartServer(ctx)
+			return api.StartServer(ctx, nil)
 		},
 	}
 
@@ -11,6 +11,8 @@ import (
 )
 
 func CacheRecordCommand() *cobra.Command {
+	var view string
+
 	cmd := &cobra.Command{
 		Use:   "cache-record <domain> <type>",
 		Short: "Pre-warm Redis cache for a specific record (domain + qtype)",
@@ -25,14 +27,15 @@ func CacheRecordCommand() *cobra.Command {
 				return err
 			}
 
-			domain := args[0]
+			domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
 			qtype := strings.ToUpper(args[1])
-			recs, err := db.FetchRecords(ctx, domain, qtype)
+			view = strings.ToLower(view)
+			recs, err := db.FetchRecords(ctx, view, domain, qtype)
 			if err != nil {
 				return err
 			}
 
-			if err := cache.CacheRecord(ctx, domain, qtype, recs); err != nil {
+			if err := cache.CacheRecord(ctx, view, domain, qtype, recs); err != nil {
 				return err
 			}
 
@@ -40,5 +43,7 @@ func CacheRecordCommand() *cobra.Command {
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&view, "view", "", "Cache the records as seen by clients of this view")
 	return cmd
 }
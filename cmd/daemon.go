@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/extremtechniker/godns/api"
 	"github.com/extremtechniker/godns/cache"
@@ -13,7 +14,7 @@ import (
 )
 
 func DaemonCommand() *cobra.Command {
-	var httpAPI bool
+	var httpAPI, httpDoH bool
 
 	cmd := &cobra.Command{
 		Use:   "daemon",
@@ -29,10 +30,19 @@ func DaemonCommand() *cobra.Command {
 				return err
 			}
 
+			// The handler must be ready before the HTTP API can serve DNS-over-HTTPS
+			if err := dns.Init(ctx); err != nil {
+				return err
+			}
+
 			// Optional HTTP API
 			if httpAPI {
+				var doh http.Handler
+				if httpDoH {
+					doh = dns.DoHHandler()
+				}
 				go func() {
-					err := api.StartServer(ctx)
+					err := api.StartServer(ctx, doh)
 					if err != nil {
 						logger.Logger.Fatal(err)
 						return
@@ -46,5 +56,6 @@ func DaemonCommand() *cobra.Command {
 	}
 
 	cmd.Flags().BoolVar(&httpAPI, "http-api", false, "Start HTTP API alongside DNS daemon")
+	cmd.Flags().BoolVar(&httpDoH, "http-doh", false, "Serve DNS-over-HTTPS at /dns-query on the HTTP API (requires --http-api)")
 	return cmd
 }
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+func GenerateKeysCommand() *cobra.Command {
+	var algorithm string
+
+	cmd := &cobra.Command{
+		Use:   "generate-keys <zone>",
+		Short: "Generate a DNSSEC KSK and ZSK for a zone and print its DS record",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+
+			zone, err := db.GetZone(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if zone == nil {
+				return fmt.Errorf("zone %s does not exist", args[0])
+			}
+
+			ksk, err := model.GenerateKey(zone.Name, model.FlagKSK, algorithm)
+			if err != nil {
+				return err
+			}
+			zsk, err := model.GenerateKey(zone.Name, model.FlagZSK, algorithm)
+			if err != nil {
+				return err
+			}
+			if err := db.AddKeys(ctx, ksk, zsk); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("DNSSEC keys generated for %s", zone.Name)
+			fmt.Println(ksk.DNSKEY(zone.TTL).ToDS(dns.SHA256).String())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&algorithm, "algorithm", "ECDSAP256SHA256", "DNSSEC algorithm (ECDSAP256SHA256, ECDSAP384SHA384, ED25519, RSASHA256, RSASHA512)")
+	return cmd
+}
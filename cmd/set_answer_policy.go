@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func SetAnswerPolicyCommand() *cobra.Command {
+	var policy model.AnswerPolicy
+
+	cmd := &cobra.Command{
+		Use:   "set-answer-policy <domain>",
+		Short: "Choose how the A/AAAA records of a name are selected and ordered in answers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			policy.Domain = args[0]
+			if err := policy.SetDefaults(); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if err := db.AddAnswerPolicy(ctx, policy); err != nil {
+				return err
+			}
+
+			logger.Logger.Infof("Answer policy set: %s %s", policy.Domain, policy.Policy)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&policy.Policy, "policy", model.PolicyShuffle, "Answer policy (shuffle, weighted, top)")
+	cmd.Flags().IntVar(&policy.Count, "count", 1, "Number of records served by the top policy")
+	return cmd
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/spf13/cobra"
+)
+
+func SetQueryACLCommand() *cobra.Command {
+	var acl model.QueryACL
+
+	cmd := &cobra.Command{
+		Use:   "set-query-acl [zone]",
+		Short: "Restrict which client networks may query a zone, or the server without a zone",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if len(args) > 0 {
+				acl.Zone = args[0]
+			}
+			if err := acl.SetDefaults(); err != nil {
+				return err
+			}
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+			if acl.Zone != "" {
+				zone, err := db.GetZone(ctx, acl.Zone)
+				if err != nil {
+					return err
+				}
+				if zone == nil {
+					return fmt.Errorf("zone %s does not exist", acl.Zone)
+				}
+			}
+			if err := db.SetQueryACL(ctx, acl); err != nil {
+				return err
+			}
+
+			target := "server"
+			if acl.Zone != "" {
+				target = "zone " + acl.Zone
+			}
+			if acl.Empty() {
+				logger.Logger.Infof("Query ACL removed: %s", target)
+			} else {
+				logger.Logger.Infof("Query ACL set: %s (allow %v, deny %v, transports %v, listen %v)", target, acl.Allow, acl.Deny, acl.Transports, acl.Listen)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&acl.Allow, "allow", nil, "Only allow queries from this CIDR, repeatable")
+	cmd.Flags().StringSliceVar(&acl.Deny, "deny", nil, "Refuse queries from this CIDR, repeatable")
+	cmd.Flags().StringSliceVar(&acl.Transports, "transport", nil, "Only apply to queries over this transport (udp, tcp, dot, doh, doq), repeatable")
+	cmd.Flags().StringSliceVar(&acl.Listen, "listen", nil, "Only apply to queries received on this local address, repeatable")
+	return cmd
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+func ShowDSCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show-ds <zone>",
+		Short: "Print the DS records to hand to the parent zone's registrar",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if err := db.InitPostgres(ctx); err != nil {
+				return err
+			}
+
+			zone, err := db.GetZone(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if zone == nil {
+				return fmt.Errorf("zone %s does not exist", args[0])
+			}
+
+			keys, err := db.FetchKeys(ctx, zone.Name)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if k.IsKSK() {
+					fmt.Println(k.DNSKEY(zone.TTL).ToDS(dns.SHA256).String())
+				}
+			}
+			return nil
+		},
+	}
+	return cmd
+}
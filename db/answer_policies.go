@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+func AddAnswerPolicy(ctx context.Context, p model.AnswerPolicy) error {
+	q := `INSERT INTO answer_policies (domain, policy, count) VALUES ($1,$2,$3)
+	ON CONFLICT (domain) DO UPDATE SET policy = $2, count = $3;`
+	_, err := PgPool.Exec(ctx, q, p.Domain, p.Policy, p.Count)
+	return err
+}
+
+func DeleteAnswerPolicy(ctx context.Context, domain string) error {
+	_, err := PgPool.Exec(ctx, `DELETE FROM answer_policies WHERE domain = $1`, domain)
+	return err
+}
+
+func FetchAnswerPolicies(ctx context.Context) ([]model.AnswerPolicy, error) {
+	rows, err := PgPool.Query(ctx, `SELECT domain, policy, count FROM answer_policies ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.AnswerPolicy
+	for rows.Next() {
+		var p model.AnswerPolicy
+		if err := rows.Scan(&p.Domain, &p.Policy, &p.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
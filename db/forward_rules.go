@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+func AddForwardRule(ctx context.Context, r model.ForwardRule) error {
+	q := `INSERT INTO forward_rules (suffix, upstreams, protocol, cache) VALUES ($1,$2,$3,$4)
+	ON CONFLICT (suffix) DO UPDATE SET upstreams = $2, protocol = $3, cache = $4;`
+	_, err := PgPool.Exec(ctx, q, r.Suffix, r.Upstreams, r.Protocol, r.Cache)
+	return err
+}
+
+func DeleteForwardRule(ctx context.Context, suffix string) error {
+	_, err := PgPool.Exec(ctx, `DELETE FROM forward_rules WHERE suffix = $1`, suffix)
+	return err
+}
+
+// FetchForwardRules returns all rules, most specific suffix first.
+func FetchForwardRules(ctx context.Context) ([]model.ForwardRule, error) {
+	rows, err := PgPool.Query(ctx, `SELECT suffix, upstreams, protocol, cache FROM forward_rules
+	ORDER BY length(suffix) DESC, suffix`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ForwardRule
+	for rows.Next() {
+		var r model.ForwardRule
+		if err := rows.Scan(&r.Suffix, &r.Upstreams, &r.Protocol, &r.Cache); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+// FetchCheckedRecords returns every record with a health check.
+func FetchCheckedRecords(ctx context.Context) ([]model.Record, error) {
+	rows, err := PgPool.Query(ctx, `SELECT `+recordColumns+` FROM dns_records WHERE health_check <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// SetHealthStatus records a change of the state of a checked endpoint.
+func SetHealthStatus(ctx context.Context, s model.HealthStatus) error {
+	q := `INSERT INTO health_status (target, healthy, last_error, changed_at) VALUES ($1,$2,$3,now())
+	ON CONFLICT (target) DO UPDATE SET healthy = $2, last_error = $3, changed_at = now();`
+	_, err := PgPool.Exec(ctx, q, s.Target, s.Healthy, s.LastError)
+	return err
+}
+
+func FetchHealthStatus(ctx context.Context) ([]model.HealthStatus, error) {
+	rows, err := PgPool.Query(ctx, `SELECT target, healthy, last_error, changed_at FROM health_status ORDER BY target`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.HealthStatus
+	for rows.Next() {
+		var s model.HealthStatus
+		if err := rows.Scan(&s.Target, &s.Healthy, &s.LastError, &s.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// journalChanges bumps the serial of the zone owning domain and records the
+// deleted and added records under the new serial, inside the caller's transaction.
+// Names outside any zone are not journaled, nor are records tagged with a view
+// or region, which are only served to some clients and never transferred.
+func journalChanges(ctx context.Context, tx pgx.Tx, domain string, deleted, added []model.Record) error {
+	deleted, added = untagged(deleted), untagged(added)
+	if len(deleted) == 0 && len(added) == 0 {
+		return nil
+	}
+	zone, err := findZone(ctx, tx, domain, "FOR UPDATE")
+	if err != nil || zone == nil {
+		return err
+	}
+
+	serial := model.NextSerial(zone.Serial, time.Now())
+	if _, err := tx.Exec(ctx, `UPDATE zones SET serial = $2 WHERE name = $1`, zone.Name, int64(serial)); err != nil {
+		return err
+	}
+	return insertJournal(ctx, tx, zone.Name, zone.Serial, serial, deleted, added)
+}
+
+// untagged returns the records that belong to no view and no region.
+func untagged(recs []model.Record) []model.Record {
+	var out []model.Record
+	for _, r := range recs {
+		if r.View == "" && r.Region == "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func insertJournal(ctx context.Context, tx pgx.Tx, zone string, from, to uint32, deleted, added []model.Record) error {
+	q := `INSERT INTO zone_journal (zone, from_serial, to_serial, op, domain, qtype, ttl, value)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`
+	steps := []struct {
+		op   string
+		recs []model.Record
+	}{{model.ChangeDelete, deleted}, {model.ChangeAdd, added}}
+	for _, step := range steps {
+		for _, r := range step.recs {
+			if _, err := tx.Exec(ctx, q, zone, int64(from), int64(to), step.op,
+				r.Domain, r.QType, r.TTL, r.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyTransfer stores the result of a zone transfer from a primary: the record
+// changes, the primary's SOA values and a journal step from the previous serial
+// to the transferred one, all in one transaction.
+func ApplyTransfer(ctx context.Context, zone model.Zone, deleted, added []model.Record) error {
+	tx, err := PgPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var from int64
+	if err := tx.QueryRow(ctx, `SELECT serial FROM zones WHERE name = $1 FOR UPDATE`, zone.Name).Scan(&from); err != nil {
+		return err
+	}
+
+	for _, r := range deleted {
+		if _, err := tx.Exec(ctx, `DELETE FROM dns_records WHERE domain = $1 AND qtype = $2 AND value = $3
+		AND view = '' AND region = ''`, r.Domain, r.QType, r.Value); err != nil {
+			return err
+		}
+	}
+	for _, r := range added {
+		if _, err := tx.Exec(ctx, `INSERT INTO dns_records (domain, qtype, ttl, value) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (domain, qtype, value, view, region) DO UPDATE SET ttl = $3`, r.Domain, r.QType, r.TTL, r.Value); err != nil {
+			return err
+		}
+	}
+
+	q := `UPDATE zones SET ns = $2, mbox = $3, serial = $4, refresh = $5, retry = $6, expire = $7, minimum = $8,
+		ttl = $9, refreshed_at = now() WHERE name = $1`
+	if _, err := tx.Exec(ctx, q, zone.Name, zone.Ns, zone.Mbox, int64(zone.Serial), zone.Refresh, zone.Retry,
+		zone.Expire, zone.Minimum, zone.TTL); err != nil {
+		return err
+	}
+	if err := insertJournal(ctx, tx, zone.Name, uint32(from), zone.Serial, deleted, added); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// FetchJournal returns the zone's changes starting at the step that moved away
+// from serial, in the order they were applied. It is empty when the journal
+// does not reach back that far.
+func FetchJournal(ctx context.Context, zone string, serial uint32) ([]model.Change, error) {
+	q := `SELECT zone, from_serial, to_serial, op, domain, qtype, ttl, value FROM zone_journal
+	WHERE zone = $1 AND id >= (SELECT min(id) FROM zone_journal WHERE zone = $1 AND from_serial = $2)
+	ORDER BY id`
+	rows, err := PgPool.Query(ctx, q, zone, int64(serial))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Change
+	for rows.Next() {
+		var c model.Change
+		var from, to int64
+		if err := rows.Scan(&c.Zone, &from, &to, &c.Op, &c.Record.Domain, &c.Record.QType, &c.Record.TTL,
+			&c.Record.Value); err != nil {
+			return nil, err
+		}
+		c.FromSerial, c.ToSerial = uint32(from), uint32(to)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// FetchZoneRecords returns every untagged record belonging to zone, excluding
+// names that belong to a more specific zone we also serve.
+func FetchZoneRecords(ctx context.Context, zone string) ([]model.Record, error) {
+	q := `SELECT ` + recordColumns + ` FROM dns_records r
+	WHERE (lower(domain) = $1 OR right(lower(domain), length($1) + 1) = '.' || $1) AND view = '' AND region = ''
+	AND NOT EXISTS (SELECT 1 FROM zones z WHERE length(z.name) > length($1)
+		AND (lower(r.domain) = z.name OR right(lower(r.domain), length(z.name) + 1) = '.' || z.name))
+	ORDER BY domain, qtype`
+	rows, err := PgPool.Query(ctx, q, zone)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
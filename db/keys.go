@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+// AddKeys stores keys in a single transaction, so a zone never ends up with
+// only some of them.
+func AddKeys(ctx context.Context, keys ...model.Key) error {
+	tx, err := PgPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := `INSERT INTO dnssec_keys (zone, flags, algorithm, public_key, private_key) VALUES ($1,$2,$3,$4,$5)`
+	for _, k := range keys {
+		if _, err := tx.Exec(ctx, q, k.Zone, int(k.Flags), int(k.Algorithm), k.PublicKey, k.PrivateKey); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func FetchKeys(ctx context.Context, zone string) ([]model.Key, error) {
+	q := `SELECT id, zone, flags, algorithm, public_key, private_key FROM dnssec_keys WHERE zone = $1 ORDER BY id`
+	rows, err := PgPool.Query(ctx, q, zone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Key
+	for rows.Next() {
+		var k model.Key
+		var flags, alg int
+		if err := rows.Scan(&k.ID, &k.Zone, &flags, &alg, &k.PublicKey, &k.PrivateKey); err != nil {
+			return nil, err
+		}
+		k.Flags, k.Algorithm = uint16(flags), uint8(alg)
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
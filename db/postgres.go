@@ -2,11 +2,15 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/extremtechniker/godns/model"
 	"github.com/extremtechniker/godns/util"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/miekg/dns"
 )
 
 var PgPool *pgxpool.Pool
@@ -43,57 +47,309 @@ func EnsureTables(ctx context.Context) error {
 		hits BIGINT NOT NULL DEFAULT 0,
 		PRIMARY KEY(domain, qtype)
 	);`
+	q3 := `CREATE TABLE IF NOT EXISTS zones (
+		name TEXT PRIMARY KEY,
+		ns TEXT NOT NULL,
+		mbox TEXT NOT NULL,
+		serial BIGINT NOT NULL,
+		refresh INT NOT NULL,
+		retry INT NOT NULL,
+		expire INT NOT NULL,
+		minimum INT NOT NULL,
+		ttl INT NOT NULL
+	);`
+	q4 := `CREATE TABLE IF NOT EXISTS dnssec_keys (
+		id SERIAL PRIMARY KEY,
+		zone TEXT NOT NULL REFERENCES zones(name) ON DELETE CASCADE,
+		flags INT NOT NULL,
+		algorithm INT NOT NULL,
+		public_key TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
 
-	if _, err := PgPool.Exec(ctx, q1); err != nil {
-		return err
-	}
-	if _, err := PgPool.Exec(ctx, q2); err != nil {
-		return err
+	q5 := `ALTER TABLE zones
+		ADD COLUMN IF NOT EXISTS transfer_acl TEXT[] NOT NULL DEFAULT '{}',
+		ADD COLUMN IF NOT EXISTS transfer_key TEXT NOT NULL DEFAULT '';`
+	q6 := `CREATE TABLE IF NOT EXISTS tsig_keys (
+		name TEXT PRIMARY KEY,
+		algorithm TEXT NOT NULL,
+		secret TEXT NOT NULL
+	);`
+	q7 := `CREATE TABLE IF NOT EXISTS zone_journal (
+		id BIGSERIAL PRIMARY KEY,
+		zone TEXT NOT NULL REFERENCES zones(name) ON DELETE CASCADE,
+		from_serial BIGINT NOT NULL,
+		to_serial BIGINT NOT NULL,
+		op TEXT NOT NULL,
+		domain TEXT NOT NULL,
+		qtype TEXT NOT NULL,
+		ttl INT NOT NULL,
+		value TEXT NOT NULL
+	);`
+
+	q8 := `ALTER TABLE zones
+		ADD COLUMN IF NOT EXISTS primary_addr TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS primary_key TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMPTZ;`
+
+	q9 := `ALTER TABLE zones ADD COLUMN IF NOT EXISTS also_notify TEXT[] NOT NULL DEFAULT '{}';`
+
+	q10 := `ALTER TABLE zones ADD COLUMN IF NOT EXISTS update_key TEXT NOT NULL DEFAULT '';`
+
+	q11 := `CREATE TABLE IF NOT EXISTS forward_rules (
+		suffix TEXT PRIMARY KEY,
+		upstreams TEXT[] NOT NULL,
+		protocol TEXT NOT NULL DEFAULT 'udp',
+		cache BOOLEAN NOT NULL DEFAULT true
+	);`
+
+	// Records may be tagged with a split-horizon view; the same value can exist once per view
+	q12 := `ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS view TEXT NOT NULL DEFAULT '';
+	ALTER TABLE dns_records DROP CONSTRAINT IF EXISTS dns_records_domain_qtype_value_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS dns_records_domain_qtype_value_view_key ON dns_records (domain, qtype, value, view);`
+	q13 := `CREATE TABLE IF NOT EXISTS views (
+		name TEXT PRIMARY KEY,
+		networks TEXT[] NOT NULL DEFAULT '{}',
+		tsig_key TEXT NOT NULL DEFAULT '',
+		listen TEXT[] NOT NULL DEFAULT '{}',
+		priority INT NOT NULL DEFAULT 0
+	);`
+	// GeoDNS: records may also be tagged with the region of the clients they are served to
+	q14 := `ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '';
+	DROP INDEX IF EXISTS dns_records_domain_qtype_value_view_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS dns_records_domain_qtype_value_view_region_key
+		ON dns_records (domain, qtype, value, view, region);`
+	// Weighted answer selection
+	q15 := `ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS weight INT NOT NULL DEFAULT 1;`
+	q16 := `CREATE TABLE IF NOT EXISTS answer_policies (
+		domain TEXT PRIMARY KEY,
+		policy TEXT NOT NULL,
+		count INT NOT NULL DEFAULT 1
+	);`
+	// Health-checked failover records and the last known state of each checked endpoint
+	q17 := `ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS health_check TEXT NOT NULL DEFAULT '';
+	ALTER TABLE dns_records ADD COLUMN IF NOT EXISTS backup BOOLEAN NOT NULL DEFAULT false;`
+	q18 := `CREATE TABLE IF NOT EXISTS health_status (
+		target TEXT PRIMARY KEY,
+		healthy BOOLEAN NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+
+	// Query ACLs of the server (zone '') and of individual zones
+	q19 := `CREATE TABLE IF NOT EXISTS query_acls (
+		zone TEXT PRIMARY KEY,
+		allow TEXT[] NOT NULL DEFAULT '{}',
+		deny TEXT[] NOT NULL DEFAULT '{}'
+	);`
+	// Response policy sources (blocklists and RPZ zones), local overrides and their hit counters
+	q20 := `CREATE TABLE IF NOT EXISTS rpz_sources (
+		name TEXT PRIMARY KEY,
+		format TEXT NOT NULL,
+		file TEXT NOT NULL DEFAULT '',
+		primary_addr TEXT NOT NULL DEFAULT '',
+		key TEXT NOT NULL DEFAULT '',
+		policy TEXT NOT NULL DEFAULT '',
+		sinkhole TEXT[] NOT NULL DEFAULT '{}',
+		refresh INT NOT NULL DEFAULT 3600,
+		priority INT NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS rpz_overrides (
+		name TEXT PRIMARY KEY,
+		policy TEXT NOT NULL,
+		sinkhole TEXT[] NOT NULL DEFAULT '{}'
+	);
+	CREATE TABLE IF NOT EXISTS rpz_hits (
+		source TEXT NOT NULL,
+		policy TEXT NOT NULL,
+		hits BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (source, policy)
+	);`
+	// Names are stored in lowercase; fold existing ones, dropping records that only differed in case
+	q21 := `DELETE FROM dns_records a USING dns_records b
+	WHERE a.domain <> lower(a.domain) AND lower(b.domain) = lower(a.domain) AND a.qtype = b.qtype AND a.value = b.value
+		AND a.view = b.view AND a.region = b.region AND (b.domain = lower(b.domain) OR b.ctid < a.ctid);
+	UPDATE dns_records SET domain = lower(domain) WHERE domain <> lower(domain);`
+	// Query ACLs can be limited to the transports queries arrive over
+	q22 := `ALTER TABLE query_acls ADD COLUMN IF NOT EXISTS transports TEXT[] NOT NULL DEFAULT '{}';`
+	// ... and to the local addresses they are received on
+	q23 := `ALTER TABLE query_acls ADD COLUMN IF NOT EXISTS listen TEXT[] NOT NULL DEFAULT '{}';`
+
+	for _, q := range []string{q1, q2, q3, q4, q5, q6, q7, q8, q9, q10, q11, q12, q13, q14, q15, q16, q17, q18, q19, q20,
+		q21, q22, q23} {
+		if _, err := PgPool.Exec(ctx, q); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// AddRecord inserts a record or updates its TTL, weight and health check.
+// Changes to records inside a zone bump the zone serial and are written to the
+// zone journal; the other settings are not part of the zone and are changed silently.
 func AddRecord(ctx context.Context, r model.Record) error {
-	q := `INSERT INTO dns_records (domain, qtype, ttl, value) VALUES ($1,$2,$3,$4)
-	ON CONFLICT (domain, qtype, value) DO UPDATE SET ttl = $3;`
-	_, err := PgPool.Exec(ctx, q, r.Domain, r.QType, r.TTL, r.Value)
-	return err
+	tx, err := PgPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if r.Weight == 0 {
+		r.Weight = 1
+	}
+
+	old := r
+	err = tx.QueryRow(ctx, `SELECT ttl, weight, health_check, backup FROM dns_records WHERE domain = $1 AND qtype = $2
+	AND value = $3 AND view = $4 AND region = $5 FOR UPDATE`, r.Domain, r.QType, r.Value, r.View, r.Region).
+		Scan(&old.TTL, &old.Weight, &old.HealthCheck, &old.Backup)
+
+	var deleted []model.Record
+	added := []model.Record{r}
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	case old == r:
+		return nil
+	case old.TTL == r.TTL:
+		added = nil
+	default:
+		deleted = append(deleted, old)
+	}
+
+	q := `INSERT INTO dns_records (domain, qtype, ttl, value, view, region, weight, health_check, backup)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	ON CONFLICT (domain, qtype, value, view, region) DO UPDATE SET ttl = $3, weight = $7, health_check = $8, backup = $9;`
+	if _, err := tx.Exec(ctx, q, r.Domain, r.QType, r.TTL, r.Value, r.View, r.Region, r.Weight, r.HealthCheck,
+		r.Backup); err != nil {
+		return err
+	}
+	if err := journalChanges(ctx, tx, r.Domain, deleted, added); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-func FetchRecords(ctx context.Context, domain, qtype string) ([]model.Record, error) {
-	q := `SELECT domain, qtype, ttl, value FROM dns_records WHERE domain = $1 AND qtype = $2`
-	rows, err := PgPool.Query(ctx, q, domain, qtype)
+// DeleteRecords removes every record of the given type at domain in view and
+// region and journals the removal.
+func DeleteRecords(ctx context.Context, domain, qtype, view, region string) error {
+	tx, err := PgPool.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `DELETE FROM dns_records WHERE domain = $1 AND qtype = $2 AND view = $3
+	AND region = $4 RETURNING `+recordColumns, domain, qtype, view, region)
+	if err != nil {
+		return err
+	}
+	deleted, err := scanRecords(rows)
+	if err != nil {
+		return err
+	}
+
+	if err := journalChanges(ctx, tx, domain, deleted, nil); err != nil {
+		return err
 	}
+	return tx.Commit(ctx)
+}
+
+// recordColumns are the columns read by scanRecords.
+const recordColumns = `domain, qtype, ttl, value, view, region, weight, health_check, backup`
+
+func scanRecords(rows pgx.Rows) ([]model.Record, error) {
 	defer rows.Close()
 
 	var out []model.Record
 	for rows.Next() {
 		var r model.Record
-		if err := rows.Scan(&r.Domain, &r.QType, &r.TTL, &r.Value); err != nil {
+		if err := rows.Scan(&r.Domain, &r.QType, &r.TTL, &r.Value, &r.View, &r.Region, &r.Weight, &r.HealthCheck,
+			&r.Backup); err != nil {
 			return nil, err
 		}
 		out = append(out, r)
 	}
-	return out, nil
+	return out, rows.Err()
 }
 
-func FetchAllRecords(ctx context.Context) ([]model.Record, error) {
-	q := `SELECT domain, qtype, ttl, value FROM dns_records`
-	rows, err := PgPool.Query(ctx, q)
+// FetchRecords returns the records of domain and qtype seen by clients of view:
+// the view's own records when it has any for the type, the untagged ones otherwise.
+// Records of every region are returned; the caller picks the client's.
+func FetchRecords(ctx context.Context, view, domain, qtype string) ([]model.Record, error) {
+	q := `SELECT ` + recordColumns + ` FROM dns_records WHERE domain = $1 AND qtype = $2 AND view = (
+		SELECT max(view) FROM dns_records WHERE domain = $1 AND qtype = $2 AND view IN ('', $3))`
+	rows, err := PgPool.Query(ctx, q, strings.ToLower(domain), qtype, view)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// NameExists reports whether any record visible in view is stored at domain or below it.
+// Names that only own children (empty non-terminals) exist as well.
+func NameExists(ctx context.Context, view, domain string) (bool, error) {
+	q := `SELECT EXISTS (SELECT 1 FROM dns_records
+	WHERE (domain = $1 OR right(domain, length($1) + 1) = '.' || $1) AND view IN ('', $2))`
+	var exists bool
+	err := PgPool.QueryRow(ctx, q, strings.ToLower(domain), view).Scan(&exists)
+	return exists, err
+}
+
+// FetchTypes returns the distinct record types visible in view at domain.
+func FetchTypes(ctx context.Context, view, domain string) ([]string, error) {
+	rows, err := PgPool.Query(ctx, `SELECT DISTINCT qtype FROM dns_records WHERE domain = $1 AND view IN ('', $2)`,
+		strings.ToLower(domain), view)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var out []model.Record
+	var out []string
 	for rows.Next() {
-		var r model.Record
-		if err := rows.Scan(&r.Domain, &r.QType, &r.TTL, &r.Value); err != nil {
+		var t string
+		if err := rows.Scan(&t); err != nil {
 			return nil, err
 		}
-		out = append(out, r)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// ClosestEncloser returns the longest ancestor of domain inside zone existing in view (RFC 4592).
+// The zone apex always exists and is returned when no closer ancestor owns records.
+func ClosestEncloser(ctx context.Context, view, zone, domain string) (string, error) {
+	var ancestors []string
+	labels := dns.SplitDomainName(strings.ToLower(domain))
+	for i := 1; i < len(labels); i++ {
+		name := strings.Join(labels[i:], ".")
+		if strings.EqualFold(name, zone) {
+			break
+		}
+		ancestors = append(ancestors, name)
+	}
+	if len(ancestors) == 0 {
+		return zone, nil
+	}
+
+	q := `SELECT name FROM unnest($1::text[]) AS name
+	WHERE EXISTS (SELECT 1 FROM dns_records
+		WHERE (domain = name OR right(domain, length(name) + 1) = '.' || name) AND view IN ('', $2))
+	ORDER BY length(name) DESC LIMIT 1`
+	var encloser string
+	err := PgPool.QueryRow(ctx, q, ancestors, view).Scan(&encloser)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return zone, nil
+	}
+	return encloser, err
+}
+
+func FetchAllRecords(ctx context.Context) ([]model.Record, error) {
+	q := `SELECT ` + recordColumns + ` FROM dns_records`
+	rows, err := PgPool.Query(ctx, q)
+	if err != nil {
+		return nil, err
 	}
-	return out, nil
+	return scanRecords(rows)
 }
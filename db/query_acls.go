@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// SetQueryACL stores the query ACL of a zone, or of the server when its zone
+// is empty. An ACL without networks is removed.
+func SetQueryACL(ctx context.Context, a model.QueryACL) error {
+	if a.Empty() {
+		return DeleteQueryACL(ctx, a.Zone)
+	}
+	q := `INSERT INTO query_acls (zone, allow, deny, transports, listen) VALUES ($1,$2,$3,$4,$5)
+	ON CONFLICT (zone) DO UPDATE SET allow = $2, deny = $3, transports = $4, listen = $5;`
+	_, err := PgPool.Exec(ctx, q, a.Zone, a.Allow, a.Deny, a.Transports, a.Listen)
+	return err
+}
+
+func DeleteQueryACL(ctx context.Context, zone string) error {
+	_, err := PgPool.Exec(ctx, `DELETE FROM query_acls WHERE zone = $1`, zone)
+	return err
+}
+
+// GetQueryACL returns the query ACL of zone, which permits everyone when none is set.
+func GetQueryACL(ctx context.Context, zone string) (model.QueryACL, error) {
+	a := model.QueryACL{Zone: zone, Allow: []string{}, Deny: []string{}, Transports: []string{}, Listen: []string{}}
+	err := PgPool.QueryRow(ctx, `SELECT allow, deny, transports, listen FROM query_acls WHERE zone = $1`, zone).
+		Scan(&a.Allow, &a.Deny, &a.Transports, &a.Listen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return a, nil
+	}
+	return a, err
+}
+
+func FetchQueryACLs(ctx context.Context) ([]model.QueryACL, error) {
+	rows, err := PgPool.Query(ctx, `SELECT zone, allow, deny, transports, listen FROM query_acls ORDER BY zone`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.QueryACL
+	for rows.Next() {
+		var a model.QueryACL
+		if err := rows.Scan(&a.Zone, &a.Allow, &a.Deny, &a.Transports, &a.Listen); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
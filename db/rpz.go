@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+const rpzSourceColumns = `name, format, file, primary_addr, key, policy, sinkhole, refresh, priority`
+
+func AddRPZSource(ctx context.Context, s model.RPZSource) error {
+	q := `INSERT INTO rpz_sources (` + rpzSourceColumns + `) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	ON CONFLICT (name) DO UPDATE SET format = $2, file = $3, primary_addr = $4, key = $5, policy = $6,
+		sinkhole = $7, refresh = $8, priority = $9;`
+	_, err := PgPool.Exec(ctx, q, s.Name, s.Format, s.File, s.Primary, s.Key, s.Policy, s.Sinkhole, s.Refresh, s.Priority)
+	return err
+}
+
+// DeleteRPZSource removes a source together with its hit counters.
+func DeleteRPZSource(ctx context.Context, name string) error {
+	q := `WITH hits AS (DELETE FROM rpz_hits WHERE source = $1) DELETE FROM rpz_sources WHERE name = $1`
+	_, err := PgPool.Exec(ctx, q, name)
+	return err
+}
+
+// FetchRPZSources returns all sources in the order they are applied.
+func FetchRPZSources(ctx context.Context) ([]model.RPZSource, error) {
+	rows, err := PgPool.Query(ctx, `SELECT `+rpzSourceColumns+` FROM rpz_sources ORDER BY priority, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.RPZSource
+	for rows.Next() {
+		var s model.RPZSource
+		if err := rows.Scan(&s.Name, &s.Format, &s.File, &s.Primary, &s.Key, &s.Policy, &s.Sinkhole,
+			&s.Refresh, &s.Priority); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func AddRPZOverride(ctx context.Context, o model.RPZOverride) error {
+	q := `INSERT INTO rpz_overrides (name, policy, sinkhole) VALUES ($1,$2,$3)
+	ON CONFLICT (name) DO UPDATE SET policy = $2, sinkhole = $3;`
+	_, err := PgPool.Exec(ctx, q, o.Name, o.Policy, o.Sinkhole)
+	return err
+}
+
+func DeleteRPZOverride(ctx context.Context, name string) error {
+	_, err := PgPool.Exec(ctx, `DELETE FROM rpz_overrides WHERE name = $1`, name)
+	return err
+}
+
+func FetchRPZOverrides(ctx context.Context) ([]model.RPZOverride, error) {
+	rows, err := PgPool.Query(ctx, `SELECT name, policy, sinkhole FROM rpz_overrides ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.RPZOverride
+	for rows.Next() {
+		var o model.RPZOverride
+		if err := rows.Scan(&o.Name, &o.Policy, &o.Sinkhole); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func IncrementRPZHit(ctx context.Context, source, policy string) error {
+	q := `INSERT INTO rpz_hits (source, policy, hits) VALUES ($1,$2,1)
+	ON CONFLICT (source, policy) DO UPDATE SET hits = rpz_hits.hits + 1`
+	_, err := PgPool.Exec(ctx, q, source, policy)
+	return err
+}
+
+func FetchRPZHits(ctx context.Context) ([]model.RPZHits, error) {
+	rows, err := PgPool.Query(ctx, `SELECT source, policy, hits FROM rpz_hits ORDER BY source, policy`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.RPZHits
+	for rows.Next() {
+		var h model.RPZHits
+		if err := rows.Scan(&h.Source, &h.Policy, &h.Hits); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/jackc/pgx/v5"
+)
+
+func AddTsigKey(ctx context.Context, k model.TsigKey) error {
+	q := `INSERT INTO tsig_keys (name, algorithm, secret) VALUES ($1,$2,$3)
+	ON CONFLICT (name) DO UPDATE SET algorithm = $2, secret = $3;`
+	_, err := PgPool.Exec(ctx, q, k.Name, k.Algorithm, k.Secret)
+	return err
+}
+
+func DeleteTsigKey(ctx context.Context, name string) error {
+	_, err := PgPool.Exec(ctx, `DELETE FROM tsig_keys WHERE name = $1`, name)
+	return err
+}
+
+// GetTsigKey returns the key with the given name, or nil if it does not exist.
+func GetTsigKey(ctx context.Context, name string) (*model.TsigKey, error) {
+	var k model.TsigKey
+	err := PgPool.QueryRow(ctx, `SELECT name, algorithm, secret FROM tsig_keys WHERE name = $1`, name).
+		Scan(&k.Name, &k.Algorithm, &k.Secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// FetchTsigKeys lists all keys without their secrets.
+func FetchTsigKeys(ctx context.Context) ([]model.TsigKey, error) {
+	rows, err := PgPool.Query(ctx, `SELECT name, algorithm FROM tsig_keys ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.TsigKey
+	for rows.Next() {
+		var k model.TsigKey
+		if err := rows.Scan(&k.Name, &k.Algorithm); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// Update applies a set of record changes to a zone atomically. The zone row is
+// locked for the duration, and committing bumps the serial once and journals
+// every change. Updates only see and change records that belong to no view or region.
+type Update struct {
+	tx      pgx.Tx
+	zone    string
+	deleted []model.Record
+	added   []model.Record
+}
+
+// BeginUpdate starts an update of zone.
+func BeginUpdate(ctx context.Context, zone string) (*Update, error) {
+	tx, err := PgPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM zones WHERE name = $1 FOR UPDATE`, zone); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return &Update{tx: tx, zone: zone}, nil
+}
+
+// Records returns the records at name, limited to qtype unless it is empty.
+func (u *Update) Records(ctx context.Context, name, qtype string) ([]model.Record, error) {
+	rows, err := u.tx.Query(ctx, `SELECT `+recordColumns+` FROM dns_records
+	WHERE lower(domain) = lower($1) AND ($2 = '' OR qtype = $2) AND view = '' AND region = ''`, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// Add inserts a record or updates its TTL. Names are stored in lowercase.
+func (u *Update) Add(ctx context.Context, r model.Record) error {
+	r.Domain = strings.ToLower(r.Domain)
+	var oldTTL int
+	err := u.tx.QueryRow(ctx, `SELECT ttl FROM dns_records WHERE domain = $1 AND qtype = $2 AND value = $3 AND view = ''
+	AND region = '' FOR UPDATE`,
+		r.Domain, r.QType, r.Value).Scan(&oldTTL)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	case oldTTL == r.TTL:
+		return nil
+	default:
+		old := r
+		old.TTL = oldTTL
+		u.deleted = append(u.deleted, old)
+	}
+
+	q := `INSERT INTO dns_records (domain, qtype, ttl, value) VALUES ($1,$2,$3,$4)
+	ON CONFLICT (domain, qtype, value, view, region) DO UPDATE SET ttl = $3;`
+	if _, err := u.tx.Exec(ctx, q, r.Domain, r.QType, r.TTL, r.Value); err != nil {
+		return err
+	}
+	u.added = append(u.added, r)
+	return nil
+}
+
+// Delete removes records at name. An empty qtype removes every type, an empty
+// value every record of the type.
+func (u *Update) Delete(ctx context.Context, name, qtype, value string) error {
+	rows, err := u.tx.Query(ctx, `DELETE FROM dns_records
+	WHERE lower(domain) = lower($1) AND ($2 = '' OR qtype = $2) AND ($3 = '' OR value = $3) AND view = '' AND region = ''
+	RETURNING `+recordColumns, name, qtype, value)
+	if err != nil {
+		return err
+	}
+	deleted, err := scanRecords(rows)
+	if err != nil {
+		return err
+	}
+	u.deleted = append(u.deleted, deleted...)
+	return nil
+}
+
+// Commit journals the changes and commits them. It returns every record that
+// was deleted or added so callers can invalidate caches.
+func (u *Update) Commit(ctx context.Context) ([]model.Record, error) {
+	if err := journalChanges(ctx, u.tx, u.zone, u.deleted, u.added); err != nil {
+		return nil, err
+	}
+	if err := u.tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return append(u.deleted, u.added...), nil
+}
+
+// Rollback discards the update. It is a no-op after Commit.
+func (u *Update) Rollback(ctx context.Context) {
+	_ = u.tx.Rollback(ctx)
+}
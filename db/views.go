@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+func AddView(ctx context.Context, v model.View) error {
+	q := `INSERT INTO views (name, networks, tsig_key, listen, priority) VALUES ($1,$2,$3,$4,$5)
+	ON CONFLICT (name) DO UPDATE SET networks = $2, tsig_key = $3, listen = $4, priority = $5;`
+	_, err := PgPool.Exec(ctx, q, v.Name, v.Networks, v.TsigKey, v.Listen, v.Priority)
+	return err
+}
+
+// DeleteView removes a view together with the records tagged with it, which are returned.
+func DeleteView(ctx context.Context, name string) ([]model.Record, error) {
+	tx, err := PgPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `DELETE FROM dns_records WHERE view = $1 RETURNING `+recordColumns, name)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := scanRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM views WHERE name = $1`, name); err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit(ctx)
+}
+
+// GetView returns the named view, or nil when it does not exist.
+func GetView(ctx context.Context, name string) (*model.View, error) {
+	views, err := FetchViews(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range views {
+		if v.Name == name {
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// FetchViews returns all views in the order they are matched.
+func FetchViews(ctx context.Context) ([]model.View, error) {
+	rows, err := PgPool.Query(ctx, `SELECT name, networks, tsig_key, listen, priority FROM views
+	ORDER BY priority, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.View
+	for rows.Next() {
+		var v model.View
+		if err := rows.Scan(&v.Name, &v.Networks, &v.TsigKey, &v.Listen, &v.Priority); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
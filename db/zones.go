@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/jackc/pgx/v5"
+)
+
+const zoneColumns = `name, ns, mbox, serial, refresh, retry, expire, minimum, ttl, transfer_acl, transfer_key,
+	also_notify, primary_addr, primary_key, update_key, refreshed_at`
+
+// querier is implemented by both the pool and transactions.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func scanZone(row pgx.Row) (model.Zone, error) {
+	var z model.Zone
+	var serial int64
+	err := row.Scan(&z.Name, &z.Ns, &z.Mbox, &serial, &z.Refresh, &z.Retry, &z.Expire, &z.Minimum, &z.TTL,
+		&z.TransferACL, &z.TransferKey, &z.AlsoNotify, &z.Primary, &z.PrimaryKey, &z.UpdateKey, &z.Refreshed)
+	z.Serial = uint32(serial)
+	return z, err
+}
+
+func AddZone(ctx context.Context, z model.Zone) error {
+	if z.TransferACL == nil {
+		z.TransferACL = []string{}
+	}
+	if z.AlsoNotify == nil {
+		z.AlsoNotify = []string{}
+	}
+	q := `INSERT INTO zones (` + zoneColumns + `) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,NULL)
+	ON CONFLICT (name) DO UPDATE SET ns = $2, mbox = $3, refresh = $5, retry = $6, expire = $7, minimum = $8, ttl = $9,
+		transfer_acl = $10, transfer_key = $11, also_notify = $12, primary_addr = $13, primary_key = $14,
+		update_key = $15;`
+	_, err := PgPool.Exec(ctx, q, z.Name, z.Ns, z.Mbox, int64(z.Serial), z.Refresh, z.Retry, z.Expire, z.Minimum, z.TTL,
+		z.TransferACL, z.TransferKey, z.AlsoNotify, z.Primary, z.PrimaryKey, z.UpdateKey)
+	return err
+}
+
+// DeleteZone removes a zone together with its query ACL.
+func DeleteZone(ctx context.Context, name string) error {
+	q := `WITH acl AS (DELETE FROM query_acls WHERE zone = $1) DELETE FROM zones WHERE name = $1`
+	_, err := PgPool.Exec(ctx, q, name)
+	return err
+}
+
+func FetchZones(ctx context.Context) ([]model.Zone, error) {
+	rows, err := PgPool.Query(ctx, `SELECT `+zoneColumns+` FROM zones ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Zone
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, z)
+	}
+	return out, rows.Err()
+}
+
+// FetchSecondaryZones returns the zones transferred from an external primary.
+func FetchSecondaryZones(ctx context.Context) ([]model.Zone, error) {
+	rows, err := PgPool.Query(ctx, `SELECT `+zoneColumns+` FROM zones WHERE primary_addr <> '' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Zone
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, z)
+	}
+	return out, rows.Err()
+}
+
+// MarkZoneRefreshed records that a secondary zone was confirmed current with its primary.
+func MarkZoneRefreshed(ctx context.Context, name string) error {
+	_, err := PgPool.Exec(ctx, `UPDATE zones SET refreshed_at = now() WHERE name = $1`, name)
+	return err
+}
+
+// GetZone returns the zone with exactly the given name, or nil if it does not exist.
+func GetZone(ctx context.Context, name string) (*model.Zone, error) {
+	q := `SELECT ` + zoneColumns + ` FROM zones WHERE name = lower(rtrim($1, '.'))`
+	z, err := scanZone(PgPool.QueryRow(ctx, q, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+// FindZone returns the most specific zone containing domain, or nil if we are not authoritative for it.
+func FindZone(ctx context.Context, domain string) (*model.Zone, error) {
+	return findZone(ctx, PgPool, domain, "")
+}
+
+func findZone(ctx context.Context, db querier, domain, lock string) (*model.Zone, error) {
+	q := `SELECT ` + zoneColumns + ` FROM zones
+	WHERE name = lower($1) OR right(lower($1), length(name) + 1) = '.' || name
+	ORDER BY length(name) DESC LIMIT 1 ` + lock
+	z, err := scanZone(db.QueryRow(ctx, q, domain))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
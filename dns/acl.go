@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// queryACLs caches the query ACLs by zone, "" for the server.
+var queryACLs = newCachedLoader(loadQueryACLs)
+
+// queryAllowed applies the query ACL of zone, or of the server when zone is
+// empty, to the client of w.
+func queryAllowed(w dns.ResponseWriter, zone string) (bool, error) {
+	acls, err := queryACLs.get()
+	if err != nil {
+		return false, err
+	}
+	acl, ok := acls[zone]
+	return !ok || !acl.AppliesTo(transport(w), util.AddrOf(w.LocalAddr())) || acl.Permits(util.AddrOf(w.RemoteAddr())), nil
+}
+
+// checkACLListeners warns about query ACLs scoped to listener addresses when
+// the daemon listens on a wildcard address, like checkViewListeners.
+func checkACLListeners(listen []string) {
+	if !wildcardListen(listen) {
+		return
+	}
+	acls, err := queryACLs.get()
+	if err != nil {
+		logger.Logger.Errorf("failed to load query ACLs: %v", err)
+		return
+	}
+	for _, a := range acls {
+		if len(a.Listen) > 0 {
+			logger.Logger.Warnf("query ACL of %s applies on listener addresses %v, but UDP queries to the wildcard "+
+				"DNS_LISTEN address cannot be told apart; list those addresses in DNS_LISTEN instead", aclTarget(a), a.Listen)
+		}
+	}
+}
+
+// aclTarget names what the ACL restricts, for messages.
+func aclTarget(a model.QueryACL) string {
+	if a.Zone == "" {
+		return "the server"
+	}
+	return "zone " + a.Zone
+}
+
+// transport returns the transport the query answered by w arrived over.
+func transport(w dns.ResponseWriter) string {
+	switch w := w.(type) {
+	case *msgWriter:
+		return w.transport
+	case dns.ConnectionStater:
+		if w.ConnectionState() != nil {
+			return model.TransportDoT
+		}
+	}
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		return model.TransportUDP
+	}
+	return model.TransportTCP
+}
+
+// loadQueryACLs reads the query ACLs from Postgres, by zone.
+func loadQueryACLs(map[string]model.QueryACL) (map[string]model.QueryACL, error) {
+	all, err := db.FetchQueryACLs(Ctx)
+	if err != nil {
+		return nil, err
+	}
+	acls := make(map[string]model.QueryACL, len(all))
+	for _, a := range all {
+		acls[a.Zone] = a
+	}
+	return acls, nil
+}
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+// seedQueryACLs replaces the cached query ACLs for the duration of the test.
+func seedQueryACLs(t *testing.T, acls ...model.QueryACL) {
+	t.Helper()
+	m := map[string]model.QueryACL{}
+	for _, a := range acls {
+		if err := a.SetDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		m[a.Zone] = a
+	}
+	seedCache(t, queryACLs, m)
+}
+
+func udpWriter(remote string) *msgWriter {
+	return newMsgWriter(model.TransportUDP, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}, &net.UDPAddr{IP: net.ParseIP(remote), Port: 5353})
+}
+
+// A public CNAME pointing into an internal zone must not be followed for
+// clients the internal zone refuses.
+func TestQueryAllowedCNAMEIntoProtectedZone(t *testing.T) {
+	seedQueryACLs(t, model.QueryACL{Zone: "internal.example", Allow: []string{"10.0.0.0/8"}})
+
+	// www.example.com. CNAME db.internal.example.
+	tests := []struct {
+		name   string
+		remote string
+		zone   string
+		want   bool
+	}{
+		{"public zone, outside client", "203.0.113.1", "example.com", true},
+		{"internal target, outside client", "203.0.113.1", "internal.example", false},
+		{"internal target, inside client", "10.1.2.3", "internal.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := client{w: udpWriter(tt.remote)}
+			got, err := queryAllowed(c.w, tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryAllowedListener(t *testing.T) {
+	seedQueryACLs(t, model.QueryACL{Allow: []string{"10.0.0.0/8"}, Listen: []string{"10.0.0.1"}, Transports: []string{model.TransportUDP}})
+
+	tests := []struct {
+		name   string
+		local  string
+		net    string
+		remote string
+		want   bool
+	}{
+		{"internal listener, inside client", "10.0.0.1", model.TransportUDP, "10.1.2.3", true},
+		{"internal listener, outside client", "10.0.0.1", model.TransportUDP, "203.0.113.1", false},
+		{"public listener, outside client", "192.0.2.1", model.TransportUDP, "203.0.113.1", true},
+		{"internal listener, other transport", "10.0.0.1", model.TransportDoT, "203.0.113.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newMsgWriter(tt.net, &net.UDPAddr{IP: net.ParseIP(tt.local), Port: 53}, &net.UDPAddr{IP: net.ParseIP(tt.remote), Port: 5353})
+			got, err := queryAllowed(w, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if err := (&model.QueryACL{Listen: []string{"not-an-address"}}).SetDefaults(); err == nil {
+		t.Error("expected an error for an invalid listener address")
+	}
+}
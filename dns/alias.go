@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// maxAliasDepth bounds how many ALIAS records are flattened for a single query.
+const maxAliasDepth = 4
+
+// flattenAlias resolves the ALIAS target and returns its addresses as qtype
+// records owned by name. The TTL is capped by the ALIAS record's own TTL.
+func flattenAlias(alias model.Record, c client, name, qtype string, depth int) ([]model.Record, error) {
+	target := strings.TrimSuffix(dns.Fqdn(alias.Value), ".")
+	if depth >= maxAliasDepth {
+		logger.Logger.Warnf("ALIAS chain for %s exceeds %d hops", name, maxAliasDepth)
+		return nil, nil
+	}
+
+	addrs, err := resolveAliasTarget(c, target, qtype, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]model.Record, 0, len(addrs))
+	for _, a := range addrs {
+		ttl := min(a.TTL, alias.TTL)
+		out = append(out, model.Record{Domain: name, QType: qtype, TTL: ttl, Value: a.Value})
+	}
+	return out, nil
+}
+
+// resolveAliasTarget returns the A/AAAA records of target, answering from our own
+// zones (as seen by the client) when we are authoritative and from the upstream resolver otherwise.
+func resolveAliasTarget(c client, target, qtype string, depth int) ([]model.Record, error) {
+	zone, err := db.FindZone(Ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if zone != nil {
+		// Targets in zones the client may not query are not flattened either
+		if ok, err := queryAllowed(c.w, zone.Name); err != nil || !ok {
+			return nil, err
+		}
+		res, err := resolveWithDepth(zone, c, target, qtype, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		var out []model.Record
+		for _, r := range res.Records {
+			if strings.EqualFold(r.QType, qtype) {
+				out = append(out, r)
+			}
+		}
+		return out, nil
+	}
+
+	key := cache.AliasKey(target, qtype)
+	var recs []model.Record
+	if s, err := cache.Rdb.Get(Ctx, key).Result(); err == nil {
+		if err := json.Unmarshal([]byte(s), &recs); err == nil {
+			logger.Logger.Debugf("alias cache hit: %s %s", target, qtype)
+			return recs, nil
+		}
+	}
+
+	recs, err = queryAliasUpstream(target, qtype)
+	if err != nil {
+		return nil, err
+	}
+	// Answers with a zero TTL must not be cached; Redis would keep them forever
+	ttl := 0
+	if len(recs) > 0 {
+		ttl = recs[0].TTL
+		for _, r := range recs {
+			ttl = min(ttl, r.TTL)
+		}
+	}
+	if ttl > 0 {
+		b, _ := json.Marshal(recs)
+		if err := cache.Rdb.Set(Ctx, key, b, time.Duration(ttl)*time.Second).Err(); err != nil {
+			logger.Logger.Errorf("failed to cache alias target: %v", err)
+		}
+	}
+	return recs, nil
+}
+
+// queryAliasUpstream asks the configured ALIAS_RESOLVER for the target addresses.
+func queryAliasUpstream(target, qtype string) ([]model.Record, error) {
+	resolver := util.MustGetenv("ALIAS_RESOLVER", "1.1.1.1:53")
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(target), dns.StringToType[qtype])
+
+	c := &dns.Client{Timeout: 2 * time.Second}
+	resp, _, err := c.ExchangeContext(Ctx, req, resolver)
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.ExchangeContext(Ctx, req, resolver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("alias upstream %s: %w", resolver, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("alias upstream %s: %s for %s", resolver, dns.RcodeToString[resp.Rcode], target)
+	}
+
+	var out []model.Record
+	for _, rr := range resp.Answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+		out = append(out, model.Record{Domain: target, QType: qtype, TTL: int(rr.Header().Ttl), Value: ip.String()})
+	}
+	return out, nil
+}
@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// settingsTTL is how long settings read from Postgres on the query path
+// (forwarding rules, views, policies, ACLs) are cached before they are reloaded.
+const settingsTTL = 30 * time.Second
+
+// cachedLoader holds a value loaded from Postgres, reloading it once it is
+// older than settingsTTL. load gets the previous value, so state such as
+// upstream health can be carried over to the new one.
+type cachedLoader[T any] struct {
+	load func(prev T) (T, error)
+
+	mu     sync.Mutex
+	value  T
+	loaded time.Time
+}
+
+func newCachedLoader[T any](load func(prev T) (T, error)) *cachedLoader[T] {
+	return &cachedLoader[T]{load: load}
+}
+
+// get returns the cached value, reloading it when stale. A failed reload is
+// retried on the next call.
+func (c *cachedLoader[T]) get() (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.loaded) < settingsTTL {
+		return c.value, nil
+	}
+
+	v, err := c.load(c.value)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	c.value, c.loaded = v, time.Now()
+	return v, nil
+}
+
+// current returns the cached value without reloading it.
+func (c *cachedLoader[T]) current() T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// seedCache makes c serve v without loading it, for the duration of the test.
+func seedCache[T any](t *testing.T, c *cachedLoader[T], v T) {
+	t.Helper()
+	c.mu.Lock()
+	c.value, c.loaded = v, time.Now()
+	c.mu.Unlock()
+	t.Cleanup(func() {
+		var zero T
+		c.mu.Lock()
+		c.value, c.loaded = zero, time.Time{}
+		c.mu.Unlock()
+	})
+}
+
+func TestCachedLoader(t *testing.T) {
+	loads := 0
+	fail := false
+	c := newCachedLoader(func(prev int) (int, error) {
+		if fail {
+			return 0, errors.New("postgres unavailable")
+		}
+		loads++
+		return prev + 1, nil
+	})
+
+	for range 3 {
+		if v, err := c.get(); err != nil || v != 1 {
+			t.Fatalf("got %d, %v, want 1", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times, want once within settingsTTL", loads)
+	}
+
+	// Stale values are reloaded from the previous one
+	c.loaded = time.Now().Add(-settingsTTL)
+	if v, err := c.get(); err != nil || v != 2 {
+		t.Errorf("got %d, %v after expiry, want 2", v, err)
+	}
+
+	// A failed reload is reported and retried, keeping the value it started from
+	c.loaded = time.Now().Add(-settingsTTL)
+	fail = true
+	if _, err := c.get(); err == nil {
+		t.Error("expected the load error")
+	}
+	if v := c.current(); v != 2 {
+		t.Errorf("current value %d after a failed reload, want 2", v)
+	}
+	fail = false
+	if v, err := c.get(); err != nil || v != 3 {
+		t.Errorf("got %d, %v after retrying, want 3", v, err)
+	}
+}
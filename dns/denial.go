@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+// addDenial turns a signed negative answer into a compact denial of existence
+// ("black lies", RFC 9824): instead of proving the absence of a name with a
+// pre-computed NSEC chain, a single NSEC is generated on the fly whose owner is
+// the missing name and whose next name is its immediate successor. NXDOMAIN is
+// reported as NODATA with the NXNAME pseudo-type in the type bitmap, so every
+// queried name appears to exist and no zone walking is possible.
+func addDenial(m *dns.Msg, zone *model.Zone, view, owner string) error {
+	types, err := typesAt(zone, view, owner)
+	if err != nil {
+		return err
+	}
+
+	if m.Rcode == dns.RcodeNameError {
+		m.Rcode = dns.RcodeSuccess
+		types = []uint16{dns.TypeNXNAME}
+	}
+	types = append(types, dns.TypeRRSIG, dns.TypeNSEC)
+	slices.Sort(types)
+	types = slices.Compact(types)
+
+	soa := negativeSOA(zone)
+	m.Ns = append(m.Ns, &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(owner),
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    soa.Hdr.Ttl,
+		},
+		NextDomain: `\000.` + dns.Fqdn(owner),
+		TypeBitMap: types,
+	})
+	return nil
+}
+
+// typesAt returns the record types visible in view that exist at owner, or at
+// the wildcard the owner would be synthesised from.
+func typesAt(zone *model.Zone, view, owner string) ([]uint16, error) {
+	name := strings.TrimSuffix(owner, ".")
+	var types []uint16
+	if strings.EqualFold(name, zone.Name) {
+		types = append(types, dns.TypeSOA)
+		if len(dnskeyRRs(zone)) > 0 {
+			types = append(types, dns.TypeDNSKEY)
+		}
+	} else if exists, err := db.NameExists(Ctx, view, name); err != nil {
+		return nil, err
+	} else if !exists {
+		encloser, err := db.ClosestEncloser(Ctx, view, zone.Name, name)
+		if err != nil {
+			return nil, err
+		}
+		name = "*." + encloser
+	}
+
+	stored, err := db.FetchTypes(Ctx, view, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range stored {
+		// ALIAS is not a wire type; it is answered with flattened A/AAAA records,
+		// which must not be denied to resolvers caching aggressively (RFC 8198)
+		if strings.EqualFold(t, "ALIAS") {
+			types = append(types, dns.TypeA, dns.TypeAAAA)
+		} else if rrtype, ok := dns.StringToType[strings.ToUpper(t)]; ok {
+			types = append(types, rrtype)
+		}
+	}
+	return types, nil
+}
+
+// negativeOwner returns the name whose absence a negative answer proves: the
+// last CNAME target in chain, or the query name.
+func negativeOwner(q dns.Question, chain []model.Record) string {
+	owner := q.Name
+	for _, r := range chain {
+		if strings.EqualFold(r.QType, "CNAME") {
+			owner = dns.Fqdn(r.Value)
+		}
+	}
+	return owner
+}
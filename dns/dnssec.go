@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+const (
+	// signatureValidity is how long generated RRSIGs are valid; they are cached for half of it.
+	signatureValidity = 7 * 24 * time.Hour
+	// signatureBackdate covers resolvers whose clocks run slightly behind ours.
+	signatureBackdate = time.Hour
+	// keyCacheTTL is how long parsed zone keys are kept in memory before re-reading Postgres.
+	keyCacheTTL = time.Minute
+)
+
+type signingKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+}
+
+type zoneKeys struct {
+	ksk, zsk []signingKey
+	dnskeys  []dns.RR
+	loaded   time.Time
+}
+
+// keyCache maps a zone name to its parsed *zoneKeys.
+var keyCache sync.Map
+
+// loadZoneKeys returns the DNSSEC keys of zone; an empty set means the zone is unsigned.
+func loadZoneKeys(zone *model.Zone) (*zoneKeys, error) {
+	if v, ok := keyCache.Load(zone.Name); ok {
+		if zk := v.(*zoneKeys); time.Since(zk.loaded) < keyCacheTTL {
+			return zk, nil
+		}
+	}
+
+	keys, err := db.FetchKeys(Ctx, zone.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	zk := &zoneKeys{loaded: time.Now()}
+	for _, k := range keys {
+		signer, err := k.Signer()
+		if err != nil {
+			logger.Logger.Errorf("skipping DNSSEC key: %v", err)
+			continue
+		}
+		sk := signingKey{dnskey: k.DNSKEY(zone.TTL), signer: signer}
+		if k.IsKSK() {
+			zk.ksk = append(zk.ksk, sk)
+		} else {
+			zk.zsk = append(zk.zsk, sk)
+		}
+		zk.dnskeys = append(zk.dnskeys, sk.dnskey)
+	}
+	keyCache.Store(zone.Name, zk)
+	return zk, nil
+}
+
+// dnskeyRRs returns the DNSKEY set published at the zone apex.
+func dnskeyRRs(zone *model.Zone) []dns.RR {
+	zk, err := loadZoneKeys(zone)
+	if err != nil {
+		logger.Logger.Errorf("failed to load DNSSEC keys for %s: %v", zone.Name, err)
+		return nil
+	}
+	return zk.dnskeys
+}
+
+// wantsDNSSEC reports whether the client set the DO bit.
+func wantsDNSSEC(req *dns.Msg) bool {
+	opt := req.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// signMsg appends RRSIGs for every RRset in the answer and authority sections.
+// RRsets synthesised from wildcards are signed as if the query name existed,
+// consistent with the compact denial of existence used for negative answers.
+func signMsg(m *dns.Msg, zone *model.Zone) {
+	m.Answer = signSection(m.Answer, zone)
+	m.Ns = signSection(m.Ns, zone)
+}
+
+func signSection(rrs []dns.RR, zone *model.Zone) []dns.RR {
+	type setKey struct {
+		owner  string
+		rrtype uint16
+	}
+	var order []setKey
+	sets := map[setKey][]dns.RR{}
+	for _, rr := range rrs {
+		k := setKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		if _, ok := sets[k]; !ok {
+			order = append(order, k)
+		}
+		sets[k] = append(sets[k], rr)
+	}
+
+	out := rrs
+	for _, k := range order {
+		signer := zone
+		if !zone.Contains(k.owner) {
+			z, err := db.FindZone(Ctx, k.owner)
+			if err != nil || z == nil {
+				continue
+			}
+			signer = z
+		}
+		out = append(out, signRRset(sets[k], signer)...)
+	}
+	return out
+}
+
+// signRRset returns RRSIGs over rrset for each applicable key of zone, using
+// Redis to avoid re-signing unchanged RRsets.
+func signRRset(rrset []dns.RR, zone *model.Zone) []dns.RR {
+	zk, err := loadZoneKeys(zone)
+	if err != nil {
+		logger.Logger.Errorf("failed to load DNSSEC keys for %s: %v", zone.Name, err)
+		return nil
+	}
+
+	// KSKs sign the DNSKEY set, ZSKs everything else; a lone key acts as both (CSK)
+	keys := zk.zsk
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY || len(keys) == 0 {
+		keys = zk.ksk
+	}
+	if len(keys) == 0 {
+		keys = zk.zsk
+	}
+
+	owner := rrset[0].Header().Name
+	qtype := dns.TypeToString[rrset[0].Header().Rrtype]
+
+	var sigs []dns.RR
+	for _, k := range keys {
+		digest := rrsetDigest(rrset, k.dnskey.KeyTag())
+		key := cache.SignatureKey(owner, qtype, digest)
+
+		if s, err := cache.Rdb.Get(Ctx, key).Result(); err == nil {
+			if rr, err := dns.NewRR(s); err == nil {
+				sigs = append(sigs, rr)
+				continue
+			}
+		}
+
+		sig, err := sign(rrset, zone, k)
+		if err != nil {
+			logger.Logger.Errorf("failed to sign %s %s: %v", owner, qtype, err)
+			continue
+		}
+		if err := cache.Rdb.Set(Ctx, key, sig.String(), signatureValidity/2).Err(); err != nil {
+			logger.Logger.Errorf("failed to cache signature: %v", err)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+func sign(rrset []dns.RR, zone *model.Zone, k signingKey) (*dns.RRSIG, error) {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   rrset[0].Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    rrset[0].Header().Ttl,
+		},
+		KeyTag:     k.dnskey.KeyTag(),
+		SignerName: dns.Fqdn(zone.Name),
+		Algorithm:  k.dnskey.Algorithm,
+		Inception:  uint32(now.Add(-signatureBackdate).Unix()),
+		Expiration: uint32(now.Add(signatureValidity).Unix()),
+	}
+	if err := sig.Sign(k.signer, rrset); err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+	return sig, nil
+}
+
+// rrsetDigest identifies an RRset's content together with the key signing it.
+func rrsetDigest(rrset []dns.RR, keyTag uint16) string {
+	lines := make([]string, len(rrset))
+	for i, rr := range rrset {
+		lines[i] = strings.ToLower(rr.String())
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", keyTag)
+	for _, l := range lines {
+		h.Write([]byte(l))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
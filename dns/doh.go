@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// DoHPath is where DNS-over-HTTPS queries are served.
+const DoHPath = "/dns-query"
+
+const (
+	dohMessageType = "application/dns-message"
+	dohJSONType    = "application/dns-json"
+)
+
+// DoHHandler serves DNS-over-HTTPS (RFC 8484): GET with a base64url ?dns=
+// parameter, POST with an application/dns-message body, and the JSON flavour
+// (?name=&type=) answered as application/dns-json. Queries go through
+// HandleDNSRequest like any other transport.
+func DoHHandler() http.Handler {
+	return http.HandlerFunc(serveDoH)
+}
+
+func serveDoH(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Query().Has("name") {
+		serveDoHJSON(w, r)
+		return
+	}
+
+	var buf []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMessageType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize+1))
+		if len(buf) > dns.MaxMsgSize {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(buf) == 0 {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil || req.Response {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	dw := newDoHWriter(r)
+	dw.verifyTsig(buf, req)
+	resp := dw.exchange(req)
+	if resp == nil {
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	setDoHCacheControl(w, resp)
+	w.Header().Set("Content-Type", dohMessageType)
+	_, _ = w.Write(dw.out)
+}
+
+// dohJSONQuestion and dohJSONRR follow the de-facto JSON format served by
+// public resolvers.
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dohJSONRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type dohJSONResponse struct {
+	Status    int               `json:"Status"`
+	TC        bool              `json:"TC"`
+	RD        bool              `json:"RD"`
+	RA        bool              `json:"RA"`
+	AD        bool              `json:"AD"`
+	CD        bool              `json:"CD"`
+	Question  []dohJSONQuestion `json:"Question"`
+	Answer    []dohJSONRR       `json:"Answer,omitempty"`
+	Authority []dohJSONRR       `json:"Authority,omitempty"`
+}
+
+func serveDoHJSON(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	name := params.Get("name")
+	if _, ok := dns.IsDomainName(name); !ok {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+
+	qtype := dns.TypeA
+	if t := params.Get("type"); t != "" {
+		if n, err := strconv.ParseUint(t, 10, 16); err == nil {
+			qtype = uint16(n)
+		} else if n, ok := dns.StringToType[strings.ToUpper(t)]; ok {
+			qtype = n
+		} else {
+			http.Error(w, "invalid type", http.StatusBadRequest)
+			return
+		}
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	req.CheckingDisabled = dohFlag(params.Get("cd"))
+	if dohFlag(params.Get("do")) {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	resp := newDoHWriter(r).exchange(req)
+	if resp == nil {
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	out := dohJSONResponse{
+		Status: resp.Rcode,
+		TC:     resp.Truncated,
+		RD:     resp.RecursionDesired,
+		RA:     resp.RecursionAvailable,
+		AD:     resp.AuthenticatedData,
+		CD:     resp.CheckingDisabled,
+	}
+	for _, q := range resp.Question {
+		out.Question = append(out.Question, dohJSONQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	out.Answer = dohJSONRRs(resp.Answer)
+	out.Authority = dohJSONRRs(resp.Ns)
+
+	setDoHCacheControl(w, resp)
+	w.Header().Set("Content-Type", dohJSONType)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func dohJSONRRs(rrs []dns.RR) []dohJSONRR {
+	var out []dohJSONRR
+	for _, rr := range rrs {
+		h := rr.Header()
+		out = append(out, dohJSONRR{
+			Name: h.Name,
+			Type: h.Rrtype,
+			TTL:  h.Ttl,
+			Data: strings.TrimPrefix(rr.String(), h.String()),
+		})
+	}
+	return out
+}
+
+func dohFlag(v string) bool {
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// setDoHCacheControl lets HTTP caches keep a response for the smallest TTL it
+// contains (RFC 8484 section 5.1). Server failures are not cached.
+func setDoHCacheControl(w http.ResponseWriter, m *dns.Msg) {
+	if m.Rcode == dns.RcodeServerFailure {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	ttl, found := uint32(0), false
+	for _, rr := range append(m.Answer, m.Ns...) {
+		if !found || rr.Header().Ttl < ttl {
+			ttl, found = rr.Header().Ttl, true
+		}
+	}
+	if found {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+}
+
+// newDoHWriter returns a writer reporting the addresses of an HTTP request.
+func newDoHWriter(r *http.Request) *msgWriter {
+	var local net.Addr = &net.TCPAddr{}
+	if a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		local = a
+	}
+	var remote net.Addr = &net.TCPAddr{}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		remote = net.TCPAddrFromAddrPort(ap)
+	}
+	return newMsgWriter(model.TransportDoH, local, remote)
+}
+
+// newDoHServer returns a standalone DoH server for addr, serving HTTPS with the
+// certificate from TLS_CERT_FILE when one is configured and plain HTTP (for use
+// behind a TLS terminating proxy) otherwise.
+func newDoHServer(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle(DoHPath, DoHHandler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	if util.MustGetenv("TLS_CERT_FILE", "") == "" {
+		logger.Logger.Warnf("TLS_CERT_FILE not set, serving DNS-over-HTTPS on %s without TLS", addr)
+		return srv, nil
+	}
+	cfg, err := tlsConfig("h2", "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = cfg
+	return srv, nil
+}
+
+// listenDoH runs a server created by newDoHServer.
+func listenDoH(srv *http.Server) error {
+	logger.Logger.Infof("DNS-over-HTTPS server listening on %s", srv.Addr)
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,253 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/extremtechniker/godns/forward"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/rpz"
+	"github.com/miekg/dns"
+)
+
+// startUpstream serves h over UDP on a free local port and returns its address.
+func startUpstream(t *testing.T, h dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: h, NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// testUpstream answers A and AAAA queries with a fixed address, and fails
+// queries for names starting with "fail.".
+func testUpstream(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	q := r.Question[0]
+	if strings.HasPrefix(q.Name, "fail.") {
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+		return
+	}
+	m.SetReply(r)
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 120}
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.1")})
+	case dns.TypeAAAA:
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("2001:db8::1")})
+	}
+	_ = w.WriteMsg(m)
+}
+
+// forwardSuffix sends queries below suffix to the upstream at addr through an
+// uncached forwarding rule, so they are answered without Postgres or Redis.
+func forwardSuffix(t *testing.T, suffix, addr string) {
+	t.Helper()
+	logger.InitLogger("error")
+
+	f, err := forward.New([]string{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedCache(t, queryACLs, map[string]model.QueryACL{})
+	seedCache(t, rpzOverrides, rpz.NewSet())
+	seedCache(t, forwardRules, []*forwardRule{{ForwardRule: model.ForwardRule{Suffix: suffix}, forwarder: f}})
+
+	oldCtx, oldAllow := Ctx, allowRecursion
+	Ctx, allowRecursion = context.Background(), []string{"0.0.0.0/0", "::/0"}
+	t.Cleanup(func() { Ctx, allowRecursion = oldCtx, oldAllow })
+}
+
+func packQuery(t *testing.T, name string, qtype uint16) []byte {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Id = 0
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func serveTestDoH(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	DoHHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+// checkDoHAnswer checks a wire format DoH response holding the test upstream's A record.
+func checkDoHAnswer(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != dohMessageType {
+		t.Errorf("content type %q, want %q", ct, dohMessageType)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=120" {
+		t.Errorf("cache control %q, want max-age=120", cc)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(rec.Body.Bytes()); err != nil {
+		t.Fatalf("failed to unpack response: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("got %s with %d answers, want one A record", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("got %v, want 192.0.2.1", resp.Answer[0])
+	}
+}
+
+func TestDoHGet(t *testing.T) {
+	forwardSuffix(t, "example.test", startUpstream(t, testUpstream))
+
+	q := base64.RawURLEncoding.EncodeToString(packQuery(t, "www.example.test.", dns.TypeA))
+	checkDoHAnswer(t, serveTestDoH(httptest.NewRequest(http.MethodGet, DoHPath+"?dns="+q, nil)))
+}
+
+func TestDoHPost(t *testing.T) {
+	forwardSuffix(t, "example.test", startUpstream(t, testUpstream))
+
+	req := httptest.NewRequest(http.MethodPost, DoHPath, bytes.NewReader(packQuery(t, "www.example.test.", dns.TypeA)))
+	req.Header.Set("Content-Type", dohMessageType)
+	checkDoHAnswer(t, serveTestDoH(req))
+}
+
+func TestDoHServerFailure(t *testing.T) {
+	forwardSuffix(t, "example.test", startUpstream(t, testUpstream))
+
+	q := base64.RawURLEncoding.EncodeToString(packQuery(t, "fail.example.test.", dns.TypeA))
+	rec := serveTestDoH(httptest.NewRequest(http.MethodGet, DoHPath+"?dns="+q, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want DNS errors reported in the message", rec.Code)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(rec.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("rcode %s, want SERVFAIL", dns.RcodeToString[resp.Rcode])
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("cache control %q, want no-store", cc)
+	}
+}
+
+func TestDoHJSON(t *testing.T) {
+	forwardSuffix(t, "example.test", startUpstream(t, testUpstream))
+
+	tests := []struct {
+		name, qtype string
+		wantType    uint16
+		wantData    string
+	}{
+		{"www.example.test", "", dns.TypeA, "192.0.2.1"},
+		{"www.example.test.", "aaaa", dns.TypeAAAA, "2001:db8::1"},
+		{"www.example.test", "28", dns.TypeAAAA, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.qtype, func(t *testing.T) {
+			params := url.Values{"name": {tt.name}}
+			if tt.qtype != "" {
+				params.Set("type", tt.qtype)
+			}
+			rec := serveTestDoH(httptest.NewRequest(http.MethodGet, DoHPath+"?"+params.Encode(), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != dohJSONType {
+				t.Errorf("content type %q, want %q", ct, dohJSONType)
+			}
+
+			var out dohJSONResponse
+			if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Status != dns.RcodeSuccess || !out.RD || !out.RA {
+				t.Errorf("got status %d, RD %t, RA %t, want a recursive NOERROR", out.Status, out.RD, out.RA)
+			}
+			if len(out.Question) != 1 || out.Question[0].Name != "www.example.test." || out.Question[0].Type != tt.wantType {
+				t.Errorf("question %+v, want www.example.test. type %d", out.Question, tt.wantType)
+			}
+			if len(out.Answer) != 1 || out.Answer[0].Type != tt.wantType || out.Answer[0].Data != tt.wantData || out.Answer[0].TTL != 120 {
+				t.Errorf("answer %+v, want %s with TTL 120", out.Answer, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestDoHErrors(t *testing.T) {
+	logger.InitLogger("error")
+	query := packQuery(t, "www.example.test.", dns.TypeA)
+	response := new(dns.Msg)
+	response.SetQuestion("www.example.test.", dns.TypeA)
+	response.Response = true
+	responseBuf, _ := response.Pack()
+
+	post := func(contentType string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, DoHPath, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"GET without query", httptest.NewRequest(http.MethodGet, DoHPath, nil), http.StatusBadRequest},
+		{"GET invalid base64", httptest.NewRequest(http.MethodGet, DoHPath+"?dns=!!!", nil), http.StatusBadRequest},
+		{"GET garbage message", httptest.NewRequest(http.MethodGet, DoHPath+"?dns=AAAA", nil), http.StatusBadRequest},
+		{"GET response message", httptest.NewRequest(http.MethodGet, DoHPath+"?dns="+base64.RawURLEncoding.EncodeToString(responseBuf), nil), http.StatusBadRequest},
+		{"POST wrong content type", post("application/octet-stream", query), http.StatusUnsupportedMediaType},
+		{"POST empty body", post(dohMessageType, nil), http.StatusBadRequest},
+		{"POST too large", post(dohMessageType, make([]byte, dns.MaxMsgSize+1)), http.StatusRequestEntityTooLarge},
+		{"PUT", httptest.NewRequest(http.MethodPut, DoHPath, bytes.NewReader(query)), http.StatusMethodNotAllowed},
+		{"JSON invalid name", httptest.NewRequest(http.MethodGet, DoHPath+"?name=a..b", nil), http.StatusBadRequest},
+		{"JSON invalid type", httptest.NewRequest(http.MethodGet, DoHPath+"?name=example.test&type=NOPE", nil), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveTestDoH(tt.req); rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoHFormatError(t *testing.T) {
+	seedCache(t, queryACLs, map[string]model.QueryACL{})
+	logger.InitLogger("error")
+
+	// A query without a question is answered before any lookup happens
+	buf, err := new(dns.Msg).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serveTestDoH(httptest.NewRequest(http.MethodGet, DoHPath+"?dns="+base64.RawURLEncoding.EncodeToString(buf), nil))
+	resp := new(dns.Msg)
+	if err := resp.Unpack(rec.Body.Bytes()); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if resp.Rcode != dns.RcodeFormatError {
+		t.Errorf("rcode %s, want FORMERR", dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// doqIdleTimeout closes connections without activity.
+	doqIdleTimeout = 30 * time.Second
+	// doqStreamTimeout bounds reading a query and writing its response on one stream.
+	doqStreamTimeout = 10 * time.Second
+	// doqMaxStreams is the number of concurrent queries allowed per connection.
+	doqMaxStreams = 100
+)
+
+// DoQ error codes (RFC 9250 section 8.4).
+const (
+	doqNoError       = 0x0
+	doqInternalError = 0x1
+	doqProtocolError = 0x2
+)
+
+// listenDoQ serves DNS-over-QUIC (RFC 9250) on addr until ctx is done. Every
+// query arrives on its own bidirectional stream, prefixed with a two byte
+// length like on TCP, and is answered on the same stream.
+func listenDoQ(ctx context.Context, addr string) error {
+	cfg, err := tlsConfig("doq")
+	if err != nil {
+		return err
+	}
+	ln, err := quic.ListenAddr(addr, cfg, &quic.Config{
+		MaxIdleTimeout:     doqIdleTimeout,
+		MaxIncomingStreams: doqMaxStreams,
+	})
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	logger.Logger.Infof("DNS-over-QUIC server listening on %s", addr)
+
+	for {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveDoQConn(ctx, conn)
+	}
+}
+
+func serveDoQConn(ctx context.Context, conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			// The client closed the connection, it went idle or we are shutting down
+			if ctx.Err() != nil {
+				_ = conn.CloseWithError(doqNoError, "")
+			}
+			return
+		}
+		go serveDoQStream(conn, stream)
+	}
+}
+
+func serveDoQStream(conn *quic.Conn, stream *quic.Stream) {
+	defer stream.Close()
+	_ = stream.SetDeadline(time.Now().Add(doqStreamTimeout))
+
+	buf, err := readDoQMsg(stream)
+	if err != nil {
+		logger.Logger.Debugf("failed to read DoQ query from %s: %v", conn.RemoteAddr(), err)
+		stream.CancelRead(doqProtocolError)
+		stream.CancelWrite(doqProtocolError)
+		return
+	}
+
+	// The message ID must be zero, anything else is a protocol error (RFC 9250 section 4.2.1)
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil || req.Id != 0 || req.Response {
+		_ = conn.CloseWithError(doqProtocolError, "invalid query")
+		return
+	}
+
+	w := newMsgWriter(model.TransportDoQ, conn.LocalAddr(), conn.RemoteAddr())
+	w.verifyTsig(buf, req)
+	if w.exchange(req) == nil {
+		stream.CancelWrite(doqInternalError)
+		return
+	}
+
+	out := make([]byte, 2, 2+len(w.out))
+	binary.BigEndian.PutUint16(out, uint16(len(w.out)))
+	if _, err := stream.Write(append(out, w.out...)); err != nil {
+		logger.Logger.Debugf("failed to write DoQ response to %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// readDoQMsg reads one length prefixed message.
+func readDoQMsg(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, errors.New("empty message")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// startDoQ serves DNS-over-QUIC on a free local port with a self-signed certificate.
+func startDoQ(t *testing.T) string {
+	t.Helper()
+	logger.InitLogger("error")
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- listenDoQ(ctx, addr) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errc; err != nil {
+			t.Errorf("listenDoQ: %v", err)
+		}
+	})
+	return addr
+}
+
+// dialDoQ connects to addr, retrying while the listener starts up.
+func dialDoQ(t *testing.T, ctx context.Context, addr string) *quic.Conn {
+	t.Helper()
+	cfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"doq"}}
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		conn, err := quic.DialAddr(dialCtx, addr, cfg, nil)
+		cancel()
+		if err == nil {
+			t.Cleanup(func() { _ = conn.CloseWithError(doqNoError, "") })
+			return conn
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("failed to dial DoQ server: %v", err)
+		}
+	}
+}
+
+// queryDoQ sends m on a new stream and returns the raw reply.
+func queryDoQ(ctx context.Context, conn *quic.Conn, m *dns.Msg) ([]byte, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2, 2+len(buf))
+	binary.BigEndian.PutUint16(out, uint16(len(buf)))
+	if _, err := stream.Write(append(out, buf...)); err != nil {
+		return nil, err
+	}
+	// The client signals the end of the query by closing its side of the stream
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(stream)
+}
+
+func TestDoQQuery(t *testing.T) {
+	addr := startDoQ(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dialDoQ(t, ctx, addr)
+
+	// A query without a question is answered before any lookup happens
+	req := new(dns.Msg)
+	req.Id = 0
+	reply, err := queryDoQ(ctx, conn, req)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if len(reply) < 2 {
+		t.Fatalf("reply too short: %d bytes", len(reply))
+	}
+	if n := int(binary.BigEndian.Uint16(reply)); n != len(reply)-2 {
+		t.Fatalf("length prefix %d, want %d", n, len(reply)-2)
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(reply[2:]); err != nil {
+		t.Fatalf("failed to unpack reply: %v", err)
+	}
+	if resp.Id != 0 {
+		t.Errorf("reply ID %d, want 0", resp.Id)
+	}
+	if !resp.Response {
+		t.Error("reply does not have the QR bit set")
+	}
+	if resp.Rcode != dns.RcodeFormatError {
+		t.Errorf("rcode %s, want FORMERR", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDoQNonZeroID(t *testing.T) {
+	addr := startDoQ(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dialDoQ(t, ctx, addr)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.Id = 1234
+	_, err := queryDoQ(ctx, conn, req)
+
+	var appErr *quic.ApplicationError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("got %v, want an application error", err)
+	}
+	if !appErr.Remote || appErr.ErrorCode != doqProtocolError {
+		t.Errorf("got error code %#x (remote %t), want DOQ_PROTOCOL_ERROR from the server", appErr.ErrorCode, appErr.Remote)
+	}
+}
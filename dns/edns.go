@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// ednsBufferSize is the UDP payload size we advertise in our OPT record.
+// 1232 bytes avoids IP fragmentation on virtually every path (DNS flag day 2020).
+func ednsBufferSize() uint16 {
+	size, err := strconv.ParseUint(util.MustGetenv("EDNS_BUFFER_SIZE", "1232"), 10, 16)
+	if err != nil || size < dns.MinMsgSize {
+		return 1232
+	}
+	return uint16(size)
+}
+
+// checkEDNS validates the OPT pseudo-record of a request (RFC 6891).
+// It returns FORMERR for more than one OPT record, BADVERS for unknown
+// EDNS versions and RcodeSuccess otherwise.
+func checkEDNS(req *dns.Msg) int {
+	count := 0
+	for _, rr := range req.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			count++
+		}
+	}
+	if count > 1 {
+		return dns.RcodeFormatError
+	}
+	if opt := req.IsEdns0(); opt != nil && opt.Version() != 0 {
+		return dns.RcodeBadVers
+	}
+	return dns.RcodeSuccess
+}
+
+// writeResponse echoes EDNS0, including the client subnet option, back to clients
+// that sent an OPT record and, on UDP, truncates the response to the negotiated
+// payload size, setting TC so the client retries over TCP. UDP responses are
+// subject to Response Rate Limiting. Responses to TSIG signed requests are
+// signed as well.
+func writeResponse(w dns.ResponseWriter, req, m *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil {
+		advertised := ednsBufferSize()
+		m.SetEdns0(advertised, opt.Do())
+		echoClientSubnet(req, m)
+		size = int(min(opt.UDPSize(), advertised))
+	}
+
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		if !rateLimit(util.AddrOf(w.RemoteAddr()), m) {
+			return
+		}
+		m.Truncate(size)
+	}
+
+	// Sign responses to validly signed requests with the same key (RFC 8945)
+	if t := req.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+
+	_ = w.WriteMsg(m)
+}
@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/forward"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// maxForwardCacheTTL caps how long upstream answers are cached.
+const maxForwardCacheTTL = 24 * time.Hour
+
+// defaultAllowRecursion lists loopback and private networks (RFC 1918, ULA).
+const defaultAllowRecursion = "127.0.0.0/8,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// forwarder answers queries outside our zones when FORWARDERS is configured.
+var forwarder *forward.Forwarder
+
+// allowRecursion lists the client networks queries are forwarded for, by the
+// forwarder and by forwarding rules alike.
+var allowRecursion []string
+
+// initForwarder sets up the forwarder from FORWARDERS, a comma separated list
+// of upstreams, and starts health checking them.
+func initForwarder(ctx context.Context) error {
+	allowRecursion = strings.Split(util.MustGetenv("ALLOW_RECURSION", defaultAllowRecursion), ",")
+	for _, s := range allowRecursion {
+		if _, err := util.ParsePrefix(s); err != nil {
+			return fmt.Errorf("invalid ALLOW_RECURSION network %q: %w", s, err)
+		}
+	}
+
+	specs := util.MustGetenv("FORWARDERS", "")
+	if specs == "" {
+		return nil
+	}
+	f, err := forward.New(strings.Split(specs, ","))
+	if err != nil {
+		return err
+	}
+	forwarder = f
+	go f.HealthCheck(ctx)
+	logger.Logger.Infof("forwarding queries outside our zones to %d upstreams", len(f.Upstreams))
+	return nil
+}
+
+// handleForward answers a recursive query from the upstream resolvers of f,
+// serving and storing the responses in Redis when useCache is set.
+func handleForward(w dns.ResponseWriter, r *dns.Msg, f *forward.Forwarder, useCache bool) {
+	if !r.RecursionDesired {
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		return
+	}
+	// Not an open resolver: recursion is only offered to trusted networks
+	if !util.PrefixesContain(allowRecursion, util.AddrOf(w.RemoteAddr())) {
+		logger.Logger.Debugf("refusing recursion for %s: not in ALLOW_RECURSION", w.RemoteAddr())
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		return
+	}
+
+	q := r.Question[0]
+	do := wantsDNSSEC(r)
+	key := cache.ForwardKey(f.String(), q.Name, dns.Type(q.Qtype).String(), do, r.CheckingDisabled)
+
+	var resp *dns.Msg
+	if useCache {
+		resp = cachedResponse(key)
+	}
+	if resp == nil {
+		req := new(dns.Msg)
+		req.SetQuestion(q.Name, q.Qtype)
+		req.CheckingDisabled = r.CheckingDisabled
+		req.SetEdns0(ednsBufferSize(), do)
+
+		var err error
+		if resp, err = f.Exchange(Ctx, req); err != nil {
+			logger.Logger.Errorf("forwarding %s %s failed: %v", q.Name, dns.Type(q.Qtype), err)
+			RespondWithRcode(w, r, dns.RcodeServerFailure)
+			return
+		}
+		if useCache {
+			cacheResponse(key, resp)
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, resp.Rcode)
+	m.RecursionAvailable = true
+	m.AuthenticatedData = resp.AuthenticatedData
+	m.Answer, m.Ns = resp.Answer, resp.Ns
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+	writeResponse(w, r, m)
+}
+
+// responseTTL returns how long a response may be cached: its smallest TTL,
+// bounded by the SOA minimum for negative answers (RFC 2308). Responses
+// without records and server failures are not cached.
+func responseTTL(m *dns.Msg) time.Duration {
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return 0
+	}
+	ttl, found := uint32(0), false
+	for _, rr := range append(append(m.Answer, m.Ns...), m.Extra...) {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		t := h.Ttl
+		if soa, ok := rr.(*dns.SOA); ok && len(m.Answer) == 0 {
+			t = min(t, soa.Minttl)
+		}
+		if !found || t < ttl {
+			ttl, found = t, true
+		}
+	}
+	return min(time.Duration(ttl)*time.Second, maxForwardCacheTTL)
+}
+
+func cacheResponse(key string, m *dns.Msg) {
+	ttl := responseTTL(m)
+	if ttl <= 0 {
+		return
+	}
+	b, err := m.Pack()
+	if err == nil {
+		err = cache.Rdb.Set(Ctx, key, b, ttl).Err()
+	}
+	if err != nil {
+		logger.Logger.Errorf("failed to cache forwarded response: %v", err)
+	}
+}
+
+// cachedResponse returns a cached upstream response with its TTLs reduced by
+// the time it spent in the cache, or nil on a miss.
+func cachedResponse(key string) *dns.Msg {
+	b, err := cache.Rdb.Get(Ctx, key).Bytes()
+	if err != nil {
+		return nil
+	}
+	remaining, err := cache.Rdb.TTL(Ctx, key).Result()
+	if err != nil || remaining <= 0 {
+		return nil
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return nil
+	}
+
+	age := uint32((responseTTL(m) - remaining) / time.Second)
+	for _, rr := range append(append(m.Answer, m.Ns...), m.Extra...) {
+		if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+			h.Ttl -= min(age, h.Ttl)
+		}
+	}
+	logger.Logger.Debugf("forward cache hit: %s", key)
+	return m
+}
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestForwardAllowRecursion(t *testing.T) {
+	forwardSuffix(t, "example.test", startUpstream(t, testUpstream))
+	allowRecursion = []string{"10.0.0.0/8", "::1"}
+
+	tests := []struct {
+		name   string
+		remote string
+		rd     bool
+		want   int
+	}{
+		{"trusted client", "10.1.2.3", true, dns.RcodeSuccess},
+		{"trusted IPv6 client", "::1", true, dns.RcodeSuccess},
+		{"other client", "203.0.113.1", true, dns.RcodeRefused},
+		{"no recursion desired", "10.1.2.3", false, dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("www.example.test.", dns.TypeA)
+			req.RecursionDesired = tt.rd
+
+			resp := udpWriter(tt.remote).exchange(req)
+			if resp == nil {
+				t.Fatal("no response")
+			}
+			if resp.Rcode != tt.want {
+				t.Errorf("rcode %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.want])
+			}
+			if tt.want == dns.RcodeSuccess && (len(resp.Answer) != 1 || !resp.RecursionAvailable) {
+				t.Errorf("got %d answers, RA %t, want the upstream answer", len(resp.Answer), resp.RecursionAvailable)
+			}
+		})
+	}
+}
+
+func TestResponseTTL(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	soa := "example.test. 3600 IN SOA ns.example.test. hostmaster.example.test. 1 7200 900 1209600 60"
+
+	tests := []struct {
+		name string
+		m    *dns.Msg
+		want time.Duration
+	}{
+		{"smallest TTL", &dns.Msg{Answer: []dns.RR{rr("a.example.test. 300 IN A 192.0.2.1"), rr("a.example.test. 120 IN A 192.0.2.2")}}, 120 * time.Second},
+		{"negative answer capped by SOA minimum", &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError}, Ns: []dns.RR{rr(soa)}}, 60 * time.Second},
+		{"capped", &dns.Msg{Answer: []dns.RR{rr("a.example.test. 2147483647 IN A 192.0.2.1")}}, maxForwardCacheTTL},
+		{"server failure", &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}, Ns: []dns.RR{rr(soa)}}, 0},
+		{"no records", &dns.Msg{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseTTL(tt.m); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/extremtechniker/godns/geo"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// geoDB locates clients for GeoDNS, nil when GEOIP_DB is not set.
+var geoDB *geo.DB
+
+// initGeo loads the databases listed in GEOIP_DB (comma separated .mmdb and CSV files).
+func initGeo() error {
+	paths := util.MustGetenv("GEOIP_DB", "")
+	if paths == "" {
+		return nil
+	}
+	db, err := geo.Open(strings.Split(paths, ","))
+	if err != nil {
+		return err
+	}
+	geoDB = db
+	logger.Logger.Infof("GeoDNS enabled with %s", paths)
+	return nil
+}
+
+// client is who a query is answered for.
+type client struct {
+	view string
+	loc  geo.Location
+	// w answers the query, for the query ACLs of the zones a chain leads into.
+	w dns.ResponseWriter
+}
+
+// clientSubnet returns the EDNS Client Subnet option of req (RFC 7871), or nil.
+func clientSubnet(req *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+// locateClient returns the location of the client of req: the subnet of its
+// ECS option when present, its source address otherwise. A source prefix
+// length of zero asks for an answer not tailored to any client.
+func locateClient(w dns.ResponseWriter, req *dns.Msg) geo.Location {
+	if geoDB == nil {
+		return geo.Location{}
+	}
+	addr := util.AddrOf(w.RemoteAddr())
+	if ecs := clientSubnet(req); ecs != nil {
+		if ecs.SourceNetmask == 0 {
+			return geo.Location{}
+		}
+		a, _ := netip.AddrFromSlice(ecs.Address)
+		if p, err := a.Unmap().Prefix(int(ecs.SourceNetmask)); err == nil {
+			addr = p.Addr()
+		}
+	}
+	return geoDB.Locate(addr)
+}
+
+// selectRegion returns the records of the most specific region of loc that
+// has any, or those without a region. tailored reports whether recs held
+// regional records, so that the answer depends on the client's location.
+func selectRegion(recs []model.Record, loc geo.Location) (selected []model.Record, tailored bool) {
+	best := len(loc.Regions)
+	for _, r := range recs {
+		if r.Region == "" {
+			continue
+		}
+		tailored = true
+		if i := slices.Index(loc.Regions, r.Region); i >= 0 && i < best {
+			best = i
+		}
+	}
+	if !tailored {
+		return recs, false
+	}
+
+	region := ""
+	if best < len(loc.Regions) {
+		region = loc.Regions[best]
+	}
+	for _, r := range recs {
+		if r.Region == region {
+			selected = append(selected, r)
+		}
+	}
+	return selected, true
+}
+
+// setECSScope stores the prefix length an answer tailored to the client's
+// location is valid for as the scope of the request's ECS option, which
+// writeResponse echoes back (RFC 7871 section 7.2.1). Without a more precise
+// database network the answer is scoped to the whole source prefix.
+func setECSScope(req *dns.Msg, loc geo.Location) {
+	ecs := clientSubnet(req)
+	if ecs == nil || geoDB == nil || ecs.SourceNetmask == 0 {
+		return
+	}
+	ecs.SourceScope = ecs.SourceNetmask
+	if loc.Bits > 0 {
+		ecs.SourceScope = uint8(loc.Bits)
+	}
+}
+
+// echoClientSubnet copies the ECS option of req, with the scope set by
+// setECSScope (zero otherwise), into the OPT record of m.
+func echoClientSubnet(req, m *dns.Msg) {
+	ecs, opt := clientSubnet(req), m.IsEdns0()
+	if ecs == nil || opt == nil {
+		return
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecs.Family,
+		SourceNetmask: ecs.SourceNetmask,
+		SourceScope:   ecs.SourceScope,
+		Address:       ecs.Address,
+	})
+}
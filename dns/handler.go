@@ -2,14 +2,14 @@ package dns
 
 import (
 	"context"
-	"encoding/json"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/extremtechniker/godns/cache"
 	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/geo"
 	"github.com/extremtechniker/godns/logger"
-	"github.com/extremtechniker/godns/model"
 	"github.com/extremtechniker/godns/util"
 	"github.com/miekg/dns"
 )
@@ -19,64 +19,199 @@ var Ctx context.Context
 
 func HandleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	if len(r.Question) == 0 {
-		m := new(dns.Msg)
-		m.SetRcode(r, dns.RcodeFormatError)
-		_ = w.WriteMsg(m)
+		RespondWithRcode(w, r, dns.RcodeFormatError)
+		return
+	}
+
+	// Clients denied by the server's query ACL may not use it at all
+	if ok, err := queryAllowed(w, ""); err != nil || !ok {
+		refuseQuery(w, r, "", err)
+		return
+	}
+
+	// Reject malformed or unsupported EDNS before doing any work
+	if rcode := checkEDNS(r); rcode != dns.RcodeSuccess {
+		RespondWithRcode(w, r, rcode)
+		return
+	}
+
+	// Requests carrying a TSIG that does not verify are answered unsigned with NOTAUTH
+	if r.IsTsig() != nil && w.TsigStatus() != nil {
+		logger.Logger.Warnf("TSIG verification failed for %s: %v", w.RemoteAddr(), w.TsigStatus())
+		RespondWithRcode(w, r, dns.RcodeNotAuth)
+		return
+	}
+
+	// NOTIFY from the primary of a secondary zone
+	if r.Opcode == dns.OpcodeNotify {
+		handleNotify(w, r)
+		return
+	}
+
+	// Dynamic updates (RFC 2136)
+	if r.Opcode == dns.OpcodeUpdate {
+		handleUpdate(w, r)
+		return
+	}
+
+	if r.Opcode != dns.OpcodeQuery {
+		RespondWithRcode(w, r, dns.RcodeNotImplemented)
 		return
 	}
 
 	q := r.Question[0]
-	domain := strings.TrimSuffix(q.Name, ".")
+	// Names are stored in lowercase; resolvers randomise the case of queries (DNS 0x20)
+	domain := strings.ToLower(strings.TrimSuffix(q.Name, "."))
 	qtype := dns.TypeToString[q.Qtype]
 
-	// 1️⃣ Try Redis cache first
-	var recs []model.Record
-	if s, err := cache.Rdb.Get(Ctx, cache.CacheKey(domain, qtype)).Result(); err == nil {
-		if err := json.Unmarshal([]byte(s), &recs); err == nil {
-			logger.Logger.Debugf("cache hit: %s %s", domain, qtype)
-			RespondWithRecords(w, r, recs, q)
-			go updateMetricServedFromCache(domain, qtype)
+	// Response policies (blocklists, RPZ) apply before any resolution
+	if q.Qtype != dns.TypeAXFR && q.Qtype != dns.TypeIXFR {
+		handled, err := applyPolicy(w, r)
+		if err != nil {
+			logger.Logger.Errorf("db response policy lookup error: %v", err)
+			RespondWithRcode(w, r, dns.RcodeServerFailure)
+			return
+		}
+		if handled {
 			return
 		}
 	}
 
-	// 2️⃣ Fetch from Postgres if not in cache
-	recs, err := db.FetchRecords(Ctx, domain, qtype)
+	// Conditional forwarding rules take precedence over our own records
+	rule, err := matchForwardRule(domain)
 	if err != nil {
-		logger.Logger.Errorf("db fetch error: %v", err)
-		m := new(dns.Msg)
-		m.SetRcode(r, dns.RcodeServerFailure)
-		_ = w.WriteMsg(m)
+		logger.Logger.Errorf("db forward rule lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	if rule != nil {
+		handleForward(w, r, rule.forwarder, rule.Cache)
+		return
+	}
+
+	// 0️⃣ Only answer for names inside one of our zones
+	zone, err := db.FindZone(Ctx, domain)
+	if err != nil {
+		logger.Logger.Errorf("db zone lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	if zone == nil && forwarder != nil {
+		handleForward(w, r, forwarder, true)
+		return
+	}
+	if zone == nil {
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		logger.Logger.Debugf("refusing query for %s: not authoritative", domain)
+		return
+	}
+
+	// Internal-only zones and the like restrict who may query them
+	if ok, err := queryAllowed(w, zone.Name); err != nil || !ok {
+		refuseQuery(w, r, zone.Name, err)
 		return
 	}
 
-	if len(recs) == 0 {
-		m := new(dns.Msg)
-		m.SetRcode(r, dns.RcodeNameError)
-		_ = w.WriteMsg(m)
-		logger.Logger.Debugf("no %s records for domain %s", qtype, domain)
+	// Secondary zones that could not be refreshed within the SOA expire time are no longer served
+	if zone.Expired(time.Now()) {
+		logger.Logger.Warnf("secondary zone %s has expired", zone.Name)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
 		return
 	}
 
-	// 3️⃣ Serve the records
-	logger.Logger.Debugf("serving record from db: %s %s", qtype, domain)
-	RespondWithRecords(w, r, recs, q)
+	// Zone transfers to secondaries
+	if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+		handleTransfer(w, r, zone)
+		return
+	}
 
-	// 4️⃣ Update metrics and optionally populate Redis
-	go updateMetricServedNotFromCache(domain, qtype)
+	// The SOA and DNSKEY set are generated from the zone itself and never stored as records
+	if (q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeDNSKEY) && strings.EqualFold(domain, zone.Name) {
+		RespondWithRecords(w, r, zone, nil, q)
+		return
+	}
+
+	// Split horizon: the client's view decides which records it sees
+	view, err := selectView(w, r)
+	if err != nil {
+		logger.Logger.Errorf("db view lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+
+	// 1️⃣ Try Redis cache first, then Postgres, then wildcards, following CNAMEs
+	c := client{view: view, loc: locateClient(w, r), w: w}
+	res, err := resolve(zone, c, domain, qtype)
+	if err != nil {
+		logger.Logger.Errorf("db fetch error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+
+	// Tell resolvers sending a client subnet which clients a GeoDNS answer applies to
+	for _, l := range res.Lookups {
+		if l.Location != "" {
+			setECSScope(r, c.loc)
+			break
+		}
+	}
+
+	// 2️⃣ Distinguish NODATA from NXDOMAIN (RFC 2308)
+	if res.Negative {
+		if res.Rcode == dns.RcodeNameError {
+			logger.Logger.Debugf("domain %s does not exist", domain)
+		} else {
+			logger.Logger.Debugf("no %s records for domain %s", qtype, domain)
+		}
+		RespondNegative(w, r, res.Zone, view, res.Rcode, res.Records)
+	} else {
+		// 3️⃣ Serve the healthy records, picked and ordered by the name's answer policy
+		recs, err := applyAnswerPolicies(filterHealthy(res.Records), res.owners())
+		if err != nil {
+			logger.Logger.Errorf("db answer policy lookup error: %v", err)
+			RespondWithRcode(w, r, dns.RcodeServerFailure)
+			return
+		}
+		RespondWithRecords(w, r, zone, recs, q)
+	}
+
+	// 4️⃣ Update metrics and optionally populate Redis, keyed by the record owner
+	for _, l := range res.Lookups {
+		if l.FromCache {
+			go updateMetricServedFromCache(l.View, l.Location, l.Source, l.QType)
+		} else {
+			go updateMetricServedNotFromCache(l.View, l.Location, l.Source, l.QType)
+		}
+	}
+}
+
+// refuseQuery answers a query denied by the query ACL of zone ("" for the
+// server) with REFUSED, or with SERVFAIL when the ACL could not be loaded.
+func refuseQuery(w dns.ResponseWriter, r *dns.Msg, zone string, err error) {
+	if err != nil {
+		logger.Logger.Errorf("db query acl lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	if zone == "" {
+		logger.Logger.Debugf("refusing query from %s: denied by server acl", w.RemoteAddr())
+	} else {
+		logger.Logger.Debugf("refusing query from %s: denied by acl of zone %s", w.RemoteAddr(), zone)
+	}
+	RespondWithRcode(w, r, dns.RcodeRefused)
 }
 
 // ---------------- Metric helpers ----------------
 
-func updateMetricServedFromCache(domain, qtype string) {
-	updateMetric(domain, qtype, true)
+func updateMetricServedFromCache(view, location, domain, qtype string) {
+	updateMetric(view, location, domain, qtype, true)
 }
 
-func updateMetricServedNotFromCache(domain, qtype string) {
-	updateMetric(domain, qtype, false)
+func updateMetricServedNotFromCache(view, location, domain, qtype string) {
+	updateMetric(view, location, domain, qtype, false)
 }
 
-func updateMetric(domain, qtype string, servedFromCache bool) {
+func updateMetric(view, location, domain, qtype string, servedFromCache bool) {
 	logger.Logger.Debugf("incrementing hits for record: %s %s", qtype, domain)
 
 	_ = db.IncrementMetric(Ctx, domain, qtype)
@@ -100,12 +235,19 @@ func updateMetric(domain, qtype string, servedFromCache bool) {
 	logger.Logger.Debugf("iserting %s %s into cache: %d hits", qtype, domain, hits)
 
 	// Fetch again from DB before caching
-	recs, err := db.FetchRecords(Ctx, domain, qtype)
+	recs, err := db.FetchRecords(Ctx, view, domain, qtype)
 	if err != nil || len(recs) == 0 {
 		return
 	}
 
-	if err := cache.CacheRecord(Ctx, domain, qtype, recs); err != nil {
+	// Regional records are cached as selected for the client's location
+	if location != "" {
+		recs, _ = selectRegion(recs, geo.ParseKey(location))
+		err = cache.CacheGeoRecord(Ctx, view, location, domain, qtype, recs)
+	} else {
+		err = cache.CacheRecord(Ctx, view, domain, qtype, recs)
+	}
+	if err != nil {
 		logger.Logger.Errorf("failed to cache record: %v", err)
 	}
 }
@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/health"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+)
+
+// healthThreshold consecutive results opposite to the current state flip it,
+// so a single lost probe does not take a record out of the answers.
+const healthThreshold = 2
+
+type healthState struct {
+	healthy bool
+	// streak counts consecutive results contradicting healthy.
+	streak int
+}
+
+// update records the result of a probe, reporting whether it flipped the state.
+func (s *healthState) update(passed bool) bool {
+	if passed == s.healthy {
+		s.streak = 0
+		return false
+	}
+	if s.streak++; s.streak < healthThreshold {
+		return false
+	}
+	s.healthy, s.streak = !s.healthy, 0
+	return true
+}
+
+// runHealthChecks probes the health checks of all records every
+// HEALTH_CHECK_INTERVAL until ctx is done, publishing their state to Redis for
+// the query path and recording state changes in Postgres.
+func runHealthChecks(ctx context.Context) {
+	interval, err := time.ParseDuration(util.MustGetenv("HEALTH_CHECK_INTERVAL", "10s"))
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	// Resume from the last known states so a restart does not flip them
+	states := map[string]*healthState{}
+	if known, err := db.FetchHealthStatus(ctx); err == nil {
+		for _, s := range known {
+			states[s.Target] = &healthState{healthy: s.Healthy}
+		}
+	} else {
+		logger.Logger.Errorf("failed to load health states: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkHealth(ctx, states, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth runs every configured check once, concurrently.
+func checkHealth(ctx context.Context, states map[string]*healthState, interval time.Duration) {
+	recs, err := db.FetchCheckedRecords(ctx)
+	if err != nil {
+		logger.Logger.Errorf("failed to fetch health checks: %v", err)
+		return
+	}
+	checks := map[string]*health.Check{}
+	for _, r := range recs {
+		c, err := r.Check()
+		if err != nil {
+			logger.Logger.Warnf("skipping health check of %s %s %s: %v", r.Domain, r.QType, r.Value, err)
+			continue
+		}
+		checks[c.Target()] = c
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(checks))
+	for target, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.Run(ctx)
+			mu.Lock()
+			results[target] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	published := make(map[string]bool, len(results))
+	for target, err := range results {
+		s, ok := states[target]
+		if !ok {
+			// New checks start healthy
+			s = &healthState{healthy: true}
+			states[target] = s
+		}
+		if s.update(err == nil) {
+			recordHealthChange(ctx, target, s.healthy, err)
+		}
+		published[target] = s.healthy
+	}
+	for target := range states {
+		if _, ok := checks[target]; !ok {
+			delete(states, target)
+		}
+	}
+
+	if err := cache.SetHealth(ctx, published, 3*interval); err != nil {
+		logger.Logger.Errorf("failed to publish health states: %v", err)
+	}
+}
+
+func recordHealthChange(ctx context.Context, target string, healthy bool, checkErr error) {
+	status := model.HealthStatus{Target: target, Healthy: healthy}
+	if healthy {
+		logger.Logger.Infof("health check %s is passing again", target)
+	} else {
+		status.LastError = checkErr.Error()
+		logger.Logger.Warnf("health check %s is failing: %v", target, checkErr)
+	}
+	if err := db.SetHealthStatus(ctx, status); err != nil {
+		logger.Logger.Errorf("failed to store health state: %v", err)
+	}
+}
+
+// filterHealthy drops records whose health check fails. Backup records are only
+// served once every other record of their set is down; when the backups are
+// down too the whole set is served rather than nothing. Without state in Redis
+// records are assumed healthy.
+func filterHealthy(recs []model.Record) []model.Record {
+	var targets []string
+	checked := make([]string, len(recs))
+	hasBackup := false
+	for i, r := range recs {
+		hasBackup = hasBackup || r.Backup
+		if c, err := r.Check(); err == nil && c != nil {
+			checked[i] = c.Target()
+			targets = append(targets, checked[i])
+		}
+	}
+	if len(targets) == 0 && !hasBackup {
+		return recs
+	}
+
+	unhealthy := map[string]bool{}
+	if len(targets) > 0 {
+		var err error
+		if unhealthy, err = cache.Unhealthy(Ctx, targets); err != nil {
+			logger.Logger.Errorf("failed to fetch health states: %v", err)
+			return recs
+		}
+	}
+	return pickHealthy(recs, checked, unhealthy)
+}
+
+// pickHealthy selects the records served for each set, in order of preference:
+// healthy primaries, healthy backups, then every record. checked holds the
+// health check target of each record, empty when it has none.
+func pickHealthy(recs []model.Record, checked []string, unhealthy map[string]bool) []model.Record {
+	type set struct{ primaries, backups, all []int }
+	sets := map[string]*set{}
+	for i, r := range recs {
+		k := strings.ToLower(r.Domain) + " " + strings.ToUpper(r.QType)
+		s := sets[k]
+		if s == nil {
+			s = &set{}
+			sets[k] = s
+		}
+		s.all = append(s.all, i)
+		if unhealthy[checked[i]] {
+			continue
+		}
+		if r.Backup {
+			s.backups = append(s.backups, i)
+		} else {
+			s.primaries = append(s.primaries, i)
+		}
+	}
+
+	serve := make([]bool, len(recs))
+	for _, s := range sets {
+		pick := s.primaries
+		if len(pick) == 0 {
+			pick = s.backups
+		}
+		if len(pick) == 0 {
+			pick = s.all
+		}
+		for _, i := range pick {
+			serve[i] = true
+		}
+	}
+
+	out := make([]model.Record, 0, len(recs))
+	for i, r := range recs {
+		if serve[i] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
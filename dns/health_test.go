@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+func TestHealthStateUpdate(t *testing.T) {
+	s := &healthState{healthy: true}
+	steps := []struct {
+		passed  bool
+		changed bool
+		healthy bool
+	}{
+		{true, false, true},
+		// A single failure is not enough to take the endpoint down
+		{false, false, true},
+		{true, false, true},
+		{false, false, true},
+		{false, true, false},
+		{false, false, false},
+		// Neither is a single success enough to bring it back
+		{true, false, false},
+		{false, false, false},
+		{true, false, false},
+		{true, true, true},
+	}
+	for i, st := range steps {
+		if changed := s.update(st.passed); changed != st.changed || s.healthy != st.healthy {
+			t.Fatalf("step %d: changed %t, healthy %t, want %t, %t", i, changed, s.healthy, st.changed, st.healthy)
+		}
+	}
+}
+
+func TestPickHealthy(t *testing.T) {
+	recs := []model.Record{
+		{Domain: "www.example.test.", QType: "A", Value: "192.0.2.1"},
+		{Domain: "www.example.test.", QType: "A", Value: "192.0.2.2"},
+		{Domain: "www.example.test.", QType: "A", Value: "192.0.2.3", Backup: true},
+		{Domain: "WWW.example.test.", QType: "aaaa", Value: "2001:db8::1"},
+		{Domain: "www.example.test.", QType: "AAAA", Value: "2001:db8::2", Backup: true},
+	}
+	checked := []string{"tcp://192.0.2.1:80", "tcp://192.0.2.2:80", "tcp://192.0.2.3:80", "tcp://[2001:db8::1]:80", ""}
+	values := func(recs []model.Record) []string {
+		var out []string
+		for _, r := range recs {
+			out = append(out, r.Value)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		unhealthy []string
+		want      []string
+	}{
+		{"all healthy", nil, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}},
+		{"one primary down", []string{"tcp://192.0.2.1:80"}, []string{"192.0.2.2", "2001:db8::1"}},
+		{"primaries down", []string{"tcp://192.0.2.1:80", "tcp://192.0.2.2:80", "tcp://[2001:db8::1]:80"}, []string{"192.0.2.3", "2001:db8::2"}},
+		{"everything down", []string{"tcp://192.0.2.1:80", "tcp://192.0.2.2:80", "tcp://192.0.2.3:80"}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unhealthy := map[string]bool{}
+			for _, target := range tt.unhealthy {
+				unhealthy[target] = true
+			}
+			if got := values(pickHealthy(recs, checked, unhealthy)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package dns
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEDepth bounds how many CNAMEs are followed for a single query.
+const maxCNAMEDepth = 8
+
+// resolution is the outcome of a query after following CNAMEs through our own zones.
+type resolution struct {
+	// Records holds the CNAME chain followed by the records of the final name.
+	Records []model.Record
+	// Zone is the zone of the last name in the chain, used for the negative SOA.
+	Zone  *model.Zone
+	Rcode int
+	// Negative is set when the last name is ours and has no data of the requested type.
+	Negative bool
+	// Lookups lists every step that produced records, for metrics and caching.
+	Lookups []*lookupResult
+}
+
+// owners maps the names of the records looked up to the owner names they were
+// read from, which differ for records synthesised from a wildcard.
+func (res *resolution) owners() map[string]string {
+	owners := map[string]string{}
+	for _, l := range res.Lookups {
+		for _, r := range l.Records {
+			owners[strings.ToLower(strings.TrimSuffix(r.Domain, "."))] = l.Source
+		}
+	}
+	return owners
+}
+
+// resolve looks up domain/qtype and, unless CNAME or ANY was asked for, follows
+// CNAMEs through our own records with loop detection and a maximum depth.
+// Chains leaving our zones are returned as-is for the client's resolver to continue.
+// A and AAAA queries on names owning an ALIAS are answered with the flattened target addresses.
+// Only records visible in the client's view are considered, and regional
+// records are picked by its location.
+func resolve(zone *model.Zone, c client, domain, qtype string) (*resolution, error) {
+	return resolveWithDepth(zone, c, domain, qtype, 0)
+}
+
+func resolveWithDepth(zone *model.Zone, c client, domain, qtype string, aliasDepth int) (*resolution, error) {
+	res := &resolution{Zone: zone, Rcode: dns.RcodeSuccess}
+	seen := map[string]bool{}
+	name := domain
+
+	for depth := 0; ; depth++ {
+		l, err := lookup(res.Zone, c, name, qtype)
+		if err != nil {
+			return nil, err
+		}
+		if len(l.Records) > 0 {
+			res.Records = append(res.Records, l.Records...)
+			res.Lookups = append(res.Lookups, l)
+			return res, nil
+		}
+		if l.Rcode == dns.RcodeNameError || qtype == "CNAME" || qtype == "ANY" {
+			res.Rcode = l.Rcode
+			res.Negative = true
+			return res, nil
+		}
+
+		cname, err := lookup(res.Zone, c, name, "CNAME")
+		if err != nil {
+			return nil, err
+		}
+		if len(cname.Records) == 0 {
+			if qtype == "A" || qtype == "AAAA" {
+				return resolveAlias(res, c, name, qtype, aliasDepth)
+			}
+			res.Negative = true
+			return res, nil
+		}
+		res.Records = append(res.Records, cname.Records[0])
+		res.Lookups = append(res.Lookups, cname)
+		seen[strings.ToLower(name)] = true
+
+		target := strings.TrimSuffix(dns.Fqdn(cname.Records[0].Value), ".")
+		if seen[strings.ToLower(target)] {
+			logger.Logger.Warnf("CNAME loop detected at %s -> %s", name, target)
+			return res, nil
+		}
+		if depth+1 >= maxCNAMEDepth {
+			logger.Logger.Warnf("CNAME chain for %s exceeds %d hops", domain, maxCNAMEDepth)
+			return res, nil
+		}
+
+		next, err := db.FindZone(Ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			// Not our data, the client's resolver continues from here
+			return res, nil
+		}
+		// Records of a zone the client may not query cannot be read through a
+		// CNAME in another one; its resolver is refused when it follows on
+		ok, err := queryAllowed(c.w, next.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return res, nil
+		}
+		res.Zone = next
+		name = target
+	}
+}
+
+// resolveAlias completes res with the flattened ALIAS of name, if it owns one.
+func resolveAlias(res *resolution, c client, name, qtype string, aliasDepth int) (*resolution, error) {
+	a, err := lookup(res.Zone, c, name, "ALIAS")
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Records) == 0 {
+		res.Negative = true
+		return res, nil
+	}
+
+	recs, err := flattenAlias(a.Records[0], c, name, qtype, aliasDepth)
+	if err != nil {
+		return nil, err
+	}
+	res.Lookups = append(res.Lookups, a)
+	if len(recs) == 0 {
+		res.Negative = true
+		return res, nil
+	}
+	res.Records = append(res.Records, recs...)
+	return res, nil
+}
+
+// lookupResult describes how a name was resolved inside one of our zones.
+type lookupResult struct {
+	// View is the view the records were looked up for.
+	View string
+	// Location is the client location key the records were selected for, empty
+	// when the record set holds no regional records.
+	Location string
+	QType    string
+	Records  []model.Record
+	// Source is the owner name the records were read from. For wildcard
+	// answers this is the "*.<closest encloser>" name, not the query name.
+	Source    string
+	FromCache bool
+	// Rcode is RcodeNameError when the name does not exist, RcodeSuccess otherwise.
+	Rcode int
+}
+
+// lookup resolves domain/qtype inside zone, applying the wildcard rules of RFC 4592:
+// a wildcard only matches names that do not exist, and only the wildcard directly
+// below the closest encloser is considered.
+func lookup(zone *model.Zone, c client, domain, qtype string) (*lookupResult, error) {
+	recs, location, fromCache, err := fetchRecords(c, domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+	res := &lookupResult{View: c.view, Location: location, QType: qtype, Records: recs, Source: domain, FromCache: fromCache, Rcode: dns.RcodeSuccess}
+	if len(recs) > 0 || strings.EqualFold(domain, zone.Name) {
+		return res, nil
+	}
+
+	exists, err := db.NameExists(Ctx, c.view, domain)
+	if err != nil || exists {
+		return res, err
+	}
+
+	encloser, err := db.ClosestEncloser(Ctx, c.view, zone.Name, domain)
+	if err != nil {
+		return nil, err
+	}
+	wildcard := cache.WildcardName(encloser)
+
+	recs, location, fromCache, err = fetchRecords(c, wildcard, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) > 0 {
+		logger.Logger.Debugf("synthesising %s %s from %s", qtype, domain, wildcard)
+		synth := make([]model.Record, len(recs))
+		for i, r := range recs {
+			r.Domain = domain
+			synth[i] = r
+		}
+		return &lookupResult{View: c.view, Location: location, QType: qtype, Records: synth, Source: wildcard, FromCache: fromCache, Rcode: dns.RcodeSuccess}, nil
+	}
+
+	// The wildcard owns other types: the synthesised name exists but has no data
+	exists, err = db.NameExists(Ctx, c.view, wildcard)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		res.Source = wildcard
+		return res, nil
+	}
+
+	res.Rcode = dns.RcodeNameError
+	return res, nil
+}
+
+// fetchRecords returns the records for domain and qtype visible in the client's
+// view from Redis, falling back to Postgres. Sets holding regional records are
+// narrowed to the client's location, whose key is returned, and cached per
+// location; sets without are cached once for everyone.
+func fetchRecords(c client, domain, qtype string) ([]model.Record, string, bool, error) {
+	location := c.loc.Key()
+	keys := []string{cache.CacheKey(c.view, domain, qtype), cache.GeoKey(c.view, location, domain, qtype)}
+	if vals, err := cache.Rdb.MGet(Ctx, keys...).Result(); err == nil {
+		for i, v := range vals {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			var recs []model.Record
+			if err := json.Unmarshal([]byte(s), &recs); err == nil {
+				logger.Logger.Debugf("cache hit: %s %s", domain, qtype)
+				if i == 0 {
+					location = ""
+				}
+				return recs, location, true, nil
+			}
+		}
+	}
+
+	recs, err := db.FetchRecords(Ctx, c.view, domain, qtype)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(recs) > 0 {
+		logger.Logger.Debugf("serving record from db: %s %s", qtype, domain)
+	}
+	recs, tailored := selectRegion(recs, c.loc)
+	if !tailored {
+		location = ""
+	}
+	return recs, location, false, nil
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/model"
+)
+
+// answerPolicies caches the policies by domain.
+var answerPolicies = newCachedLoader(loadAnswerPolicies)
+
+// applyAnswerPolicies selects and orders the A and AAAA record sets of recs
+// according to the policy of their owner. It runs on every query, after the
+// cache lookup, so cached sets are rotated too. Other records keep their place.
+// owners maps the names of the records to the owner names they were read from,
+// so sets synthesised from a wildcard get the policy of the wildcard.
+func applyAnswerPolicies(recs []model.Record, owners map[string]string) ([]model.Record, error) {
+	policies, err := answerPolicies.get()
+	if err != nil || len(policies) == 0 {
+		return recs, err
+	}
+
+	sets := map[string][]model.Record{}
+	for _, r := range recs {
+		if k, ok := addressSet(r); ok {
+			sets[k] = append(sets[k], r)
+		}
+	}
+
+	out := make([]model.Record, 0, len(recs))
+	for _, r := range recs {
+		k, ok := addressSet(r)
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		set, pending := sets[k]
+		if !pending {
+			continue
+		}
+		delete(sets, k)
+		if p, ok := answerPolicyOf(policies, owners, r.Domain); ok {
+			set = applyAnswerPolicy(p, set)
+		}
+		out = append(out, set...)
+	}
+	return out, nil
+}
+
+// answerPolicyOf returns the policy of the name, or else of the owner it was read from.
+func answerPolicyOf(policies map[string]model.AnswerPolicy, owners map[string]string, name string) (model.AnswerPolicy, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if p, ok := policies[name]; ok {
+		return p, true
+	}
+	owner, ok := owners[name]
+	if !ok {
+		return model.AnswerPolicy{}, false
+	}
+	p, ok := policies[strings.ToLower(strings.TrimSuffix(owner, "."))]
+	return p, ok
+}
+
+// addressSet returns the key of the A or AAAA record set r belongs to.
+func addressSet(r model.Record) (string, bool) {
+	qtype := strings.ToUpper(r.QType)
+	if qtype != "A" && qtype != "AAAA" {
+		return "", false
+	}
+	return strings.ToLower(r.Domain) + " " + qtype, true
+}
+
+func applyAnswerPolicy(p model.AnswerPolicy, set []model.Record) []model.Record {
+	set = weightedOrder(set)
+	switch p.Policy {
+	case model.PolicyWeighted:
+		return set[:1]
+	case model.PolicyTop:
+		return set[:min(p.Count, len(set))]
+	}
+	return set
+}
+
+// weightedOrder returns recs in a random order where each position goes to a
+// record with a probability proportional to its weight (Efraimidis-Spirakis).
+func weightedOrder(recs []model.Record) []model.Record {
+	keys := make([]float64, len(recs))
+	for i, r := range recs {
+		keys[i] = math.Pow(rand.Float64(), 1/float64(max(r.Weight, 1)))
+	}
+	out := make([]model.Record, len(recs))
+	idx := make([]int, len(recs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return keys[idx[a]] > keys[idx[b]] })
+	for i, j := range idx {
+		out[i] = recs[j]
+	}
+	return out
+}
+
+// loadAnswerPolicies reads the policies from Postgres, by domain.
+func loadAnswerPolicies(map[string]model.AnswerPolicy) (map[string]model.AnswerPolicy, error) {
+	policies, err := db.FetchAnswerPolicies(Ctx)
+	if err != nil {
+		return nil, err
+	}
+	byDomain := make(map[string]model.AnswerPolicy, len(policies))
+	for _, p := range policies {
+		byDomain[p.Domain] = p
+	}
+	return byDomain, nil
+}
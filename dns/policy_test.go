@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/extremtechniker/godns/model"
+)
+
+func TestAnswerPolicyOfWildcard(t *testing.T) {
+	seedCache(t, answerPolicies, map[string]model.AnswerPolicy{
+		"*.example.test":   {Domain: "*.example.test", Policy: model.PolicyTop, Count: 1},
+		"www.example.test": {Domain: "www.example.test", Policy: model.PolicyTop, Count: 2},
+	})
+	set := func(name string) []model.Record {
+		return []model.Record{
+			{Domain: name, QType: "A", TTL: 300, Value: "192.0.2.1"},
+			{Domain: name, QType: "A", TTL: 300, Value: "192.0.2.2"},
+			{Domain: name, QType: "A", TTL: 300, Value: "192.0.2.3"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		owners map[string]string
+		want   int
+	}{
+		// Synthesised from the wildcard, the set gets its policy
+		{"host.example.test", map[string]string{"host.example.test": "*.example.test"}, 1},
+		// A policy of the name itself takes precedence
+		{"www.example.test", map[string]string{"www.example.test": "*.example.test"}, 2},
+		{"host.example.test", map[string]string{"host.example.test": "host.example.test"}, 3},
+		{"host.example.test", nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := applyAnswerPolicies(set(tt.name), tt.owners)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != tt.want {
+				t.Errorf("got %d records, want %d", len(recs), tt.want)
+			}
+		})
+	}
+}
+
+func TestResolutionOwners(t *testing.T) {
+	res := &resolution{Lookups: []*lookupResult{
+		{Source: "www.example.test", Records: []model.Record{{Domain: "www.example.test", QType: "CNAME", Value: "host.example.test"}}},
+		{Source: "*.example.test", Records: []model.Record{{Domain: "Host.example.test.", QType: "A", Value: "192.0.2.1"}}},
+	}}
+	owners := res.owners()
+	if owners["www.example.test"] != "www.example.test" || owners["host.example.test"] != "*.example.test" {
+		t.Errorf("got %v", owners)
+	}
+}
@@ -2,53 +2,139 @@ package dns
 
 import (
 	"context"
-	"net"
+	"errors"
+	"net/http"
 	"strings"
 
 	"github.com/extremtechniker/godns/logger"
 	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
 	"github.com/miekg/dns"
 )
 
-// RunDaemon starts the DNS server listening on the specified address
-func RunDaemon(ctx context.Context, listen string) error {
+// Init prepares the query handler. It must be called before any query is
+// handled, including DNS-over-HTTPS queries served by the HTTP API.
+func Init(ctx context.Context) error {
 	Ctx = ctx // set global context for handler
 
 	dns.HandleFunc(".", HandleDNSRequest)
 
-	server := &dns.Server{
-		Addr: listen,
-		Net:  "udp",
-		NotifyStartedFunc: func() {
-			logger.Logger.Infof("DNS server listening on %s/udp", listen)
-		},
+	// GeoDNS databases for regional records
+	if err := initGeo(); err != nil {
+		return err
 	}
 
-	// Optionally also start TCP listener
-	tcpServer := &dns.Server{
-		Addr: listen,
-		Net:  "tcp",
-	}
+	// Response Rate Limiting of UDP answers
+	initRRL()
+
+	// Recursive forwarding for names outside our zones
+	return initForwarder(ctx)
+}
+
+// RunDaemon starts the DNS server listening on the specified addresses, a comma
+// separated list, along with the background jobs of the daemon. Init must have
+// been called before.
+func RunDaemon(ctx context.Context, listen string) error {
+	// Keep secondary zones in sync with their primaries
+	go runSecondaries(ctx)
+
+	// Blocklists and response policy zones
+	go runPolicies(ctx)
+
+	// Health checks of failover records
+	go runHealthChecks(ctx)
 
-	// Run UDP and TCP servers concurrently
-	errChan := make(chan error, 2)
+	// Upstream health of conditional forwarding rules
+	go checkForwardRules(ctx)
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			errChan <- err
+	// A UDP and a TCP listener per address. Binding the addresses views match on
+	// individually lets UDP queries tell them apart.
+	var servers []*dns.Server
+	addrs := strings.Split(listen, ",")
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		servers = append(servers, &dns.Server{
+			Addr:          addr,
+			Net:           "udp",
+			UDPSize:       dns.MaxMsgSize,
+			TsigProvider:  tsigProvider{},
+			MsgAcceptFunc: acceptMsg,
+			NotifyStartedFunc: func() {
+				logger.Logger.Infof("DNS server listening on %s/udp", addr)
+			},
+		}, &dns.Server{
+			Addr:          addr,
+			Net:           "tcp",
+			TsigProvider:  tsigProvider{},
+			MsgAcceptFunc: acceptMsg,
+		})
+	}
+	checkViewListeners(addrs)
+	checkACLListeners(addrs)
+
+	// Optional DNS-over-TLS listener (RFC 7858)
+	if dotListen := util.MustGetenv("DOT_LISTEN", ""); dotListen != "" {
+		cfg, err := tlsConfig("dot")
+		if err != nil {
+			return err
 		}
-	}()
-	go func() {
-		if err := tcpServer.ListenAndServe(); err != nil {
-			errChan <- err
+		servers = append(servers, &dns.Server{
+			Addr:          dotListen,
+			Net:           "tcp-tls",
+			TLSConfig:     cfg,
+			TsigProvider:  tsigProvider{},
+			MsgAcceptFunc: acceptMsg,
+			NotifyStartedFunc: func() {
+				logger.Logger.Infof("DNS-over-TLS server listening on %s", dotListen)
+			},
+		})
+	}
+
+	// Optional DNS-over-HTTPS listener (RFC 8484)
+	var dohServer *http.Server
+	if dohListen := util.MustGetenv("DOH_LISTEN", ""); dohListen != "" {
+		srv, err := newDoHServer(dohListen)
+		if err != nil {
+			return err
 		}
-	}()
+		dohServer = srv
+	}
+
+	// Run all servers concurrently
+	errChan := make(chan error, len(servers)+2)
+
+	for _, srv := range servers {
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				errChan <- err
+			}
+		}()
+	}
+	// Optional DNS-over-QUIC listener (RFC 9250)
+	if doqListen := util.MustGetenv("DOQ_LISTEN", ""); doqListen != "" {
+		go func() {
+			if err := listenDoQ(ctx, doqListen); err != nil {
+				errChan <- err
+			}
+		}()
+	}
+	if dohServer != nil {
+		go func() {
+			if err := listenDoH(dohServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- err
+			}
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
 		logger.Logger.Infof("shutting down DNS server")
-		_ = server.ShutdownContext(ctx)
-		_ = tcpServer.ShutdownContext(ctx)
+		for _, srv := range servers {
+			_ = srv.ShutdownContext(ctx)
+		}
+		if dohServer != nil {
+			_ = dohServer.Shutdown(ctx)
+		}
 		return nil
 	case err := <-errChan:
 		return err
@@ -56,68 +142,79 @@ func RunDaemon(ctx context.Context, listen string) error {
 }
 
 // RespondWithRecords writes DNS records to the response message.
-func RespondWithRecords(w dns.ResponseWriter, req *dns.Msg, recs []model.Record, q dns.Question) {
+// Answers are always authoritative for the given zone.
+func RespondWithRecords(w dns.ResponseWriter, req *dns.Msg, zone *model.Zone, recs []model.Record, q dns.Question) {
 	m := new(dns.Msg)
 	m.SetReply(req)
+	m.Authoritative = true
+
+	// The SOA and DNSKEY set are synthesised from the zone for the apex
+	if strings.EqualFold(dns.Fqdn(zone.Name), q.Name) {
+		if q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, zone.SOA())
+		}
+		if q.Qtype == dns.TypeDNSKEY || q.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, dnskeyRRs(zone)...)
+		}
+	}
+
+	m.Answer = append(m.Answer, answerRRs(recs, q)...)
+	if wantsDNSSEC(req) {
+		signMsg(m, zone)
+	}
+
+	writeResponse(w, req, m)
+}
+
+// RespondNegative writes an NXDOMAIN or NODATA (NOERROR without answers) response
+// carrying the zone SOA in the authority section for negative caching (RFC 2308).
+// Any CNAMEs followed before reaching the missing name are kept in the answer section.
+// The denial of existence for signed zones only covers records visible in view.
+func RespondNegative(w dns.ResponseWriter, req *dns.Msg, zone *model.Zone, view string, rcode int, chain []model.Record) {
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	m.Authoritative = true
+	m.Answer = append(m.Answer, answerRRs(chain, req.Question[0])...)
+	m.Ns = append(m.Ns, negativeSOA(zone))
+	if wantsDNSSEC(req) && len(dnskeyRRs(zone)) > 0 {
+		if err := addDenial(m, zone, view, negativeOwner(req.Question[0], chain)); err != nil {
+			logger.Logger.Errorf("failed to build denial of existence: %v", err)
+		}
+		signMsg(m, zone)
+	}
+
+	writeResponse(w, req, m)
+}
 
+// negativeSOA returns the zone SOA with its TTL capped to the negative caching TTL.
+func negativeSOA(zone *model.Zone) *dns.SOA {
+	soa := zone.SOA()
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
+// RespondWithRcode writes an empty response carrying only the given rcode.
+func RespondWithRcode(w dns.ResponseWriter, req *dns.Msg, rcode int) {
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	writeResponse(w, req, m)
+}
+
+// answerRRs converts records into answer RRs, keeping the requested type (or all for ANY)
+// and any CNAMEs that were followed to reach it.
+func answerRRs(recs []model.Record, q dns.Question) []dns.RR {
+	var out []dns.RR
 	for _, r := range recs {
-		// Only include matching QType or ANY
-		if strings.EqualFold(r.QType, dns.TypeToString[q.Qtype]) || q.Qtype == dns.TypeANY {
-			switch strings.ToUpper(r.QType) {
-			case "A":
-				rr := &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(r.Domain),
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(r.TTL),
-					},
-					A: net.ParseIP(r.Value).To4(),
-				}
-				if rr.A != nil {
-					m.Answer = append(m.Answer, rr)
-				}
-
-			case "AAAA":
-				rr := &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(r.Domain),
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(r.TTL),
-					},
-					AAAA: net.ParseIP(r.Value),
-				}
-				if rr.AAAA != nil {
-					m.Answer = append(m.Answer, rr)
-				}
-
-			case "CNAME":
-				rr := &dns.CNAME{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(r.Domain),
-						Rrtype: dns.TypeCNAME,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(r.TTL),
-					},
-					Target: dns.Fqdn(r.Value),
-				}
-				m.Answer = append(m.Answer, rr)
-
-			case "TXT":
-				rr := &dns.TXT{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(r.Domain),
-						Rrtype: dns.TypeTXT,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(r.TTL),
-					},
-					Txt: []string{r.Value},
-				}
-				m.Answer = append(m.Answer, rr)
+		if strings.EqualFold(r.QType, dns.TypeToString[q.Qtype]) || strings.EqualFold(r.QType, "CNAME") || q.Qtype == dns.TypeANY {
+			rr, err := r.RR()
+			if err != nil {
+				logger.Logger.Warnf("skipping invalid %s record for %s: %v", r.QType, r.Domain, err)
+				continue
 			}
+			out = append(out, rr)
 		}
 	}
-
-	_ = w.WriteMsg(m)
+	return out
 }
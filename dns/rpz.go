@@ -0,0 +1,242 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/rpz"
+	"github.com/miekg/dns"
+)
+
+const (
+	// rpzTick is how often the sources are checked for changes and due reloads.
+	rpzTick = 30 * time.Second
+	// rpzOverrideSource is the source name hits of local overrides are counted under.
+	rpzOverrideSource = "override"
+)
+
+// policySource is a loaded response policy source.
+type policySource struct {
+	source model.RPZSource
+	rules  *rpz.Set
+	// next is when the source is due to be reloaded.
+	next time.Time
+}
+
+// policySources holds the loaded sources in the order they are applied.
+var policySources struct {
+	sync.RWMutex
+	sources []*policySource
+}
+
+// rpzOverrides caches the local overrides.
+var rpzOverrides = newCachedLoader(loadRPZOverrides)
+
+// runPolicies loads the response policy sources and keeps them current until
+// ctx is done: changed sources are reloaded right away, the others every
+// refresh interval. A source that fails to load keeps its previous rules.
+func runPolicies(ctx context.Context) {
+	ticker := time.NewTicker(rpzTick)
+	defer ticker.Stop()
+	for {
+		loadPolicySources(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func loadPolicySources(ctx context.Context) {
+	all, err := db.FetchRPZSources(ctx)
+	if err != nil {
+		logger.Logger.Errorf("failed to fetch response policy sources: %v", err)
+		return
+	}
+
+	policySources.RLock()
+	loaded := map[string]*policySource{}
+	for _, ps := range policySources.sources {
+		loaded[ps.source.Name] = ps
+	}
+	policySources.RUnlock()
+
+	sources := make([]*policySource, 0, len(all))
+	for _, src := range all {
+		ps := loaded[src.Name]
+		if ps != nil && reflect.DeepEqual(ps.source, src) && time.Now().Before(ps.next) {
+			sources = append(sources, ps)
+			continue
+		}
+		rules, err := loadPolicySource(src)
+		if err != nil {
+			logger.Logger.Errorf("failed to load response policy source %s: %v", src.Name, err)
+			if ps != nil {
+				sources = append(sources, ps)
+			}
+			continue
+		}
+		logger.Logger.Infof("response policy source %s loaded: %d rules", src.Name, rules.Len())
+		next := time.Now().Add(time.Duration(src.Refresh) * time.Second)
+		sources = append(sources, &policySource{source: src, rules: rules, next: next})
+	}
+
+	policySources.Lock()
+	policySources.sources = sources
+	policySources.Unlock()
+}
+
+// loadPolicySource reads the rules of a source from its file or primary.
+func loadPolicySource(src model.RPZSource) (*rpz.Set, error) {
+	var rule rpz.Rule
+	if src.Policy != "" {
+		var err error
+		if rule, err = src.Rule(); err != nil {
+			return nil, err
+		}
+	}
+
+	if src.Format == model.RPZFormatZone {
+		rrs, err := policyZoneRecords(src)
+		if err != nil {
+			return nil, err
+		}
+		rules, skipped := rpz.FromZone(src.Name, rrs)
+		if skipped > 0 {
+			logger.Logger.Warnf("skipped %d unsupported records in response policy zone %s", skipped, src.Name)
+		}
+		if src.Policy != "" {
+			rules.Override(rule)
+		}
+		return rules, nil
+	}
+
+	f, err := os.Open(src.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if src.Format == model.RPZFormatHosts {
+		return rpz.ParseHosts(f, rule)
+	}
+	return rpz.ParseDomains(f, rule)
+}
+
+// policyZoneRecords returns the records of a response policy zone, read from
+// its file or transferred from its primary via AXFR.
+func policyZoneRecords(src model.RPZSource) ([]dns.RR, error) {
+	if src.File != "" {
+		f, err := os.Open(src.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return rpz.ReadZone(f, src.Name, src.File)
+	}
+
+	secrets, keyName, alg, err := primaryTsig(src.Key)
+	if err != nil {
+		return nil, err
+	}
+	xfr := new(dns.Msg)
+	xfr.SetAxfr(dns.Fqdn(src.Name))
+	if keyName != "" {
+		xfr.SetTsig(keyName, alg, 300, time.Now().Unix())
+	}
+
+	tr := &dns.Transfer{TsigSecret: secrets}
+	env, err := tr.In(xfr, primaryAddr(src.Primary))
+	if err != nil {
+		return nil, fmt.Errorf("transfer: %w", err)
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return nil, fmt.Errorf("transfer: %w", e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	return rrs, nil
+}
+
+// loadRPZOverrides reads the local overrides from Postgres.
+func loadRPZOverrides(*rpz.Set) (*rpz.Set, error) {
+	all, err := db.FetchRPZOverrides(Ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := rpz.NewSet()
+	for _, o := range all {
+		rule, err := o.Rule()
+		if err != nil {
+			logger.Logger.Warnf("skipping response policy override for %s: %v", o.Name, err)
+			continue
+		}
+		rules.Add(o.Name, rule)
+	}
+	return rules, nil
+}
+
+// matchPolicy returns the rule applying to name and the source it comes from:
+// local overrides first, then the sources by priority.
+func matchPolicy(name string) (rpz.Rule, string, bool, error) {
+	overrides, err := rpzOverrides.get()
+	if err != nil {
+		return rpz.Rule{}, "", false, err
+	}
+	if rule, ok := overrides.Match(name); ok {
+		return rule, rpzOverrideSource, true, nil
+	}
+
+	policySources.RLock()
+	defer policySources.RUnlock()
+	for _, ps := range policySources.sources {
+		if rule, ok := ps.rules.Match(name); ok {
+			return rule, ps.source.Name, true, nil
+		}
+	}
+	return rpz.Rule{}, "", false, nil
+}
+
+// applyPolicy answers r according to the response policy of its name and
+// reports whether it did. Passthru and names without a policy are left to
+// normal resolution.
+func applyPolicy(w dns.ResponseWriter, r *dns.Msg) (bool, error) {
+	q := r.Question[0]
+	rule, source, ok, err := matchPolicy(q.Name)
+	if err != nil || !ok {
+		return false, err
+	}
+	go countPolicyHit(source, rule.Policy)
+	logger.Logger.Debugf("response policy %s of %s applied to %s", rule.Policy, source, q.Name)
+
+	m := new(dns.Msg)
+	switch rule.Policy {
+	case rpz.Passthru:
+		return false, nil
+	case rpz.NXDomain:
+		m.SetRcode(r, dns.RcodeNameError)
+	case rpz.NoData:
+		m.SetReply(r)
+	case rpz.Redirect:
+		m.SetReply(r)
+		m.Answer = rule.Answer(q)
+	}
+	m.RecursionAvailable = forwarder != nil
+	writeResponse(w, r, m)
+	return true, nil
+}
+
+func countPolicyHit(source string, policy rpz.Policy) {
+	if err := db.IncrementRPZHit(Ctx, source, string(policy)); err != nil {
+		logger.Logger.Errorf("failed to count response policy hit: %v", err)
+	}
+}
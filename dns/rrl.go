@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/rrl"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// limiter rate limits UDP responses, nil when RRL_RESPONSES_PER_SECOND is not set.
+var limiter *rrl.Limiter
+
+// rrlLogOnly only logs what the limiter would do.
+var rrlLogOnly bool
+
+// initRRL sets up Response Rate Limiting from the RRL_* variables.
+func initRRL() {
+	rps := envInt("RRL_RESPONSES_PER_SECOND", 0)
+	if rps <= 0 {
+		return
+	}
+	cfg := rrl.Config{
+		ResponsesPerSecond: rps,
+		Slip:               envInt("RRL_SLIP", 2),
+		IPv4PrefixLen:      min(max(envInt("RRL_IPV4_PREFIX", 24), 0), 32),
+		IPv6PrefixLen:      min(max(envInt("RRL_IPV6_PREFIX", 56), 0), 128),
+	}
+	limiter = rrl.New(cfg)
+	rrlLogOnly, _ = strconv.ParseBool(util.MustGetenv("RRL_LOG_ONLY", "false"))
+
+	mode := "enforcing"
+	if rrlLogOnly {
+		mode = "log only"
+	}
+	logger.Logger.Infof("response rate limiting at %d responses per second per /%d and /%d, slip %d (%s)",
+		rps, cfg.IPv4PrefixLen, cfg.IPv6PrefixLen, cfg.Slip, mode)
+}
+
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(util.MustGetenv(key, strconv.Itoa(def)))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// rateLimit accounts the UDP response m to its client network and returns
+// false when it must be dropped. Slipped responses are cut down in place to
+// an empty truncated reply, which a genuine client retries over TCP.
+func rateLimit(addr netip.Addr, m *dns.Msg) bool {
+	if limiter == nil || !addr.IsValid() {
+		return true
+	}
+	token := responseToken(m)
+	action, first := limiter.Check(addr, token, time.Now())
+	if action == rrl.Send {
+		return true
+	}
+
+	network := limiter.Network(addr)
+	switch {
+	case rrlLogOnly:
+		if first {
+			logger.Logger.Infof("would rate limit %s responses to %s", token, network)
+		}
+		return true
+	case first:
+		logger.Logger.Warnf("rate limiting %s responses to %s", token, network)
+	}
+
+	if action == rrl.Drop {
+		return false
+	}
+	m.Truncated = true
+	m.Answer, m.Ns = nil, nil
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+	return true
+}
+
+// responseToken identifies identical responses: qname/qtype/rcode. NXDOMAIN
+// responses are accounted to their zone instead, so random names under the
+// same zone share a bucket. That includes the NODATA answers compact denial
+// of existence (addDenial) gives for missing names, marked by NXNAME.
+func responseToken(m *dns.Msg) string {
+	name, qtype := ".", "NONE"
+	if len(m.Question) > 0 {
+		name, qtype = strings.ToLower(m.Question[0].Name), dns.Type(m.Question[0].Qtype).String()
+	}
+	rcode := m.Rcode
+	if rcode == dns.RcodeSuccess && deniesName(m) {
+		rcode = dns.RcodeNameError
+	}
+	if rcode == dns.RcodeNameError {
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				name, qtype = "*."+strings.ToLower(rr.Header().Name), "ANY"
+				break
+			}
+		}
+	}
+	return name + "/" + qtype + "/" + dns.RcodeToString[rcode]
+}
+
+// deniesName reports whether m is a compact denial of the existence of a name.
+func deniesName(m *dns.Msg) bool {
+	if len(m.Answer) > 0 {
+		return false
+	}
+	for _, rr := range m.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok && slices.Contains(nsec.TypeBitMap, dns.TypeNXNAME) {
+			return true
+		}
+	}
+	return false
+}
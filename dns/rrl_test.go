@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+func TestResponseToken(t *testing.T) {
+	zone := &model.Zone{Name: "example.test", Ns: "ns.example.test", Mbox: "hostmaster.example.test", Serial: 1, TTL: 3600, Minimum: 60}
+	negative := func(name string, rcode int, types ...uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		m.Rcode = rcode
+		m.Ns = []dns.RR{negativeSOA(zone)}
+		if types != nil {
+			m.Ns = append(m.Ns, &dns.NSEC{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET}, NextDomain: `\000.` + name, TypeBitMap: types})
+		}
+		return m
+	}
+	answer := new(dns.Msg)
+	answer.SetQuestion("WWW.example.test.", dns.TypeA)
+	answer.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "www.example.test.", Rrtype: dns.TypeA, Class: dns.ClassINET}}}
+
+	tests := []struct {
+		name string
+		m    *dns.Msg
+		want string
+	}{
+		{"answer", answer, "www.example.test./A/NOERROR"},
+		{"nxdomain", negative("a1b2.example.test.", dns.RcodeNameError), "*.example.test./ANY/NXDOMAIN"},
+		// Compact denial answers NODATA for missing names, which still share the zone's bucket
+		{"compact nxdomain", negative("c3d4.example.test.", dns.RcodeSuccess, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME), "*.example.test./ANY/NXDOMAIN"},
+		{"nodata", negative("www.example.test.", dns.RcodeSuccess), "www.example.test./A/NOERROR"},
+		{"signed nodata", negative("www.example.test.", dns.RcodeSuccess, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC), "www.example.test./A/NOERROR"},
+		{"no question", new(dns.Msg), "./NONE/NOERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseToken(tt.m); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/forward"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+)
+
+// forwardRule is a rule with the forwarder for its upstreams.
+type forwardRule struct {
+	model.ForwardRule
+	forwarder *forward.Forwarder
+}
+
+// forwardRules caches the rules, ordered most specific first.
+var forwardRules = newCachedLoader(loadForwardRules)
+
+// matchForwardRule returns the rule with the longest suffix matching domain, or nil.
+func matchForwardRule(domain string) (*forwardRule, error) {
+	rules, err := forwardRules.get()
+	if err != nil {
+		return nil, err
+	}
+	// Rules are ordered most specific first
+	for _, r := range rules {
+		if r.Matches(domain) {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// loadForwardRules reads the rules from Postgres. Forwarders of rules unchanged
+// since prev are kept so their upstream health survives reloads.
+func loadForwardRules(prev []*forwardRule) ([]*forwardRule, error) {
+	rules, err := db.FetchForwardRules(Ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*forwardRule, 0, len(rules))
+	for _, r := range rules {
+		if old := findForwardRule(prev, r); old != nil {
+			out = append(out, old)
+			continue
+		}
+		f, err := forward.New(r.Specs())
+		if err != nil {
+			logger.Logger.Errorf("skipping forwarding rule for %s: %v", r.Suffix, err)
+			continue
+		}
+		out = append(out, &forwardRule{ForwardRule: r, forwarder: f})
+	}
+	return out, nil
+}
+
+func findForwardRule(rules []*forwardRule, r model.ForwardRule) *forwardRule {
+	for _, old := range rules {
+		if old.Suffix == r.Suffix && old.Cache == r.Cache && slices.Equal(old.Specs(), r.Specs()) {
+			return old
+		}
+	}
+	return nil
+}
+
+// checkForwardRules health checks the upstreams of all forwarding rules until ctx is done.
+func checkForwardRules(ctx context.Context) {
+	ticker := time.NewTicker(forward.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, r := range forwardRules.current() {
+				r.forwarder.Probe(ctx)
+			}
+		}
+	}
+}
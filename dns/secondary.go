@@ -0,0 +1,356 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/notify"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// secondaryTick is how often secondary zones are checked for a due refresh.
+const secondaryTick = 15 * time.Second
+
+// refreshRequests carries names of secondary zones to refresh immediately, fed by NOTIFY.
+var refreshRequests = make(chan string, 64)
+
+// runSecondaries keeps secondary zones in sync with their primaries: each zone is
+// checked every SOA refresh interval (retry after failures) and whenever its
+// primary sends a NOTIFY.
+func runSecondaries(ctx context.Context) {
+	next := map[string]time.Time{}
+	check := func(only string) {
+		zones, err := db.FetchSecondaryZones(ctx)
+		if err != nil {
+			logger.Logger.Errorf("failed to load secondary zones: %v", err)
+			return
+		}
+		for _, z := range zones {
+			if only != "" && !strings.EqualFold(only, z.Name) {
+				continue
+			}
+			if only == "" && time.Now().Before(next[z.Name]) {
+				continue
+			}
+			if err := refreshSecondary(ctx, z); err != nil {
+				logger.Logger.Errorf("refresh of secondary zone %s from %s failed: %v", z.Name, z.Primary, err)
+				next[z.Name] = time.Now().Add(time.Duration(z.Retry) * time.Second)
+				continue
+			}
+			next[z.Name] = time.Now().Add(time.Duration(z.Refresh) * time.Second)
+		}
+	}
+
+	ticker := time.NewTicker(secondaryTick)
+	defer ticker.Stop()
+
+	check("")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check("")
+		case name := <-refreshRequests:
+			check(name)
+		}
+	}
+}
+
+// refreshSecondary compares the primary's SOA serial with ours and transfers
+// the zone when it changed, using IXFR once we hold a copy.
+func refreshSecondary(ctx context.Context, zone model.Zone) error {
+	addr := primaryAddr(zone.Primary)
+	secrets, keyName, alg, err := primaryTsig(zone.PrimaryKey)
+	if err != nil {
+		return err
+	}
+	sign := func(m *dns.Msg) {
+		if keyName != "" {
+			m.SetTsig(keyName, alg, 300, time.Now().Unix())
+		}
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(zone.Name), dns.TypeSOA)
+	sign(req)
+	c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second, TsigSecret: secrets}
+	resp, _, err := c.ExchangeContext(ctx, req, addr)
+	if err != nil {
+		return fmt.Errorf("SOA query: %w", err)
+	}
+	var soa *dns.SOA
+	for _, rr := range resp.Answer {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+		}
+	}
+	if soa == nil {
+		return fmt.Errorf("SOA query: %s without SOA", dns.RcodeToString[resp.Rcode])
+	}
+
+	loaded := zone.Refreshed != nil
+	if loaded && !model.SerialNewer(soa.Serial, zone.Serial) {
+		return db.MarkZoneRefreshed(ctx, zone.Name)
+	}
+
+	xfr := new(dns.Msg)
+	if loaded {
+		xfr.SetIxfr(dns.Fqdn(zone.Name), zone.Serial, dns.Fqdn(zone.Ns), dns.Fqdn(zone.Mbox))
+	} else {
+		xfr.SetAxfr(dns.Fqdn(zone.Name))
+	}
+	sign(xfr)
+
+	tr := &dns.Transfer{TsigSecret: secrets}
+	env, err := tr.In(xfr, addr)
+	if err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return fmt.Errorf("transfer: %w", e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+
+	deleted, added, err := transferChanges(zone, rrs)
+	if err != nil {
+		return err
+	}
+
+	updated := zoneFromSOA(zone, soa)
+	if err := db.ApplyTransfer(ctx, updated, deleted, added); err != nil {
+		return err
+	}
+	if err := cache.Invalidate(ctx, append(deleted, added...)); err != nil {
+		logger.Logger.Errorf("failed to invalidate cache for %s: %v", zone.Name, err)
+	}
+
+	logger.Logger.Infof("secondary zone %s transferred from %s: serial %d -> %d (%d deleted, %d added)",
+		zone.Name, zone.Primary, zone.Serial, updated.Serial, len(deleted), len(added))
+
+	// Pass the change on to our own secondaries
+	go func() {
+		if err := notify.Zone(ctx, zone.Name); err != nil {
+			logger.Logger.Errorf("failed to notify secondaries: %v", err)
+		}
+	}()
+	return nil
+}
+
+// transferChanges turns an AXFR or IXFR response into the records to delete and add.
+func transferChanges(zone model.Zone, rrs []dns.RR) ([]model.Record, []model.Record, error) {
+	if len(rrs) < 2 {
+		// A lone SOA means we are already current
+		return nil, nil, nil
+	}
+	if _, ok := rrs[0].(*dns.SOA); !ok {
+		return nil, nil, fmt.Errorf("transfer does not start with SOA")
+	}
+
+	// IXFR: old SOA, deletions, new SOA, additions, repeated, then the final SOA
+	if _, incremental := rrs[1].(*dns.SOA); incremental && len(rrs) > 2 {
+		changes := newChangeSet()
+		// Every SOA switches between the deletions and additions of a step
+		adding := true
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			if _, ok := rr.(*dns.SOA); ok {
+				adding = !adding
+				continue
+			}
+			if r, ok := model.RecordFromRR(rr); ok {
+				if adding {
+					changes.add(r)
+				} else {
+					changes.delete(r)
+				}
+			}
+		}
+		deleted, added := changes.result()
+		return deleted, added, nil
+	}
+
+	// AXFR (or IXFR falling back to a full transfer): diff against what we hold
+	current, err := db.FetchZoneRecords(Ctx, zone.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	deleted, added := fullTransferChanges(zone, current, rrs)
+	return deleted, added, nil
+}
+
+// fullTransferChanges diffs the records of a full transfer against the current
+// records of the zone.
+func fullTransferChanges(zone model.Zone, current []model.Record, rrs []dns.RR) ([]model.Record, []model.Record) {
+	changes := newChangeSet()
+	for _, r := range current {
+		changes.delete(r)
+	}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if r, ok := model.RecordFromRR(rr); ok {
+			changes.add(r)
+		} else if _, soa := rr.(*dns.SOA); !soa {
+			logger.Logger.Debugf("skipping unsupported %s record in transfer of %s", dns.TypeToString[rr.Header().Rrtype], zone.Name)
+		}
+	}
+	return changes.result()
+}
+
+// changeSet folds a sequence of deletions and additions into their net effect.
+type changeSet struct {
+	deleted map[string]model.Record
+	added   map[string]model.Record
+	order   []string
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{deleted: map[string]model.Record{}, added: map[string]model.Record{}}
+}
+
+func changeKey(r model.Record) string {
+	return strings.ToLower(r.Domain) + "|" + strings.ToUpper(r.QType) + "|" + r.Value
+}
+
+func (c *changeSet) delete(r model.Record) {
+	k := changeKey(r)
+	if _, ok := c.added[k]; ok {
+		delete(c.added, k)
+		return
+	}
+	c.deleted[k] = r
+	c.order = append(c.order, k)
+}
+
+func (c *changeSet) add(r model.Record) {
+	k := changeKey(r)
+	if old, ok := c.deleted[k]; ok && old.TTL == r.TTL {
+		// Deleted and re-added unchanged: no net change
+		delete(c.deleted, k)
+		return
+	}
+	c.added[k] = r
+	c.order = append(c.order, k)
+}
+
+func (c *changeSet) result() (deleted, added []model.Record) {
+	seen := map[string]bool{}
+	for _, k := range c.order {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if r, ok := c.deleted[k]; ok {
+			deleted = append(deleted, r)
+		}
+		if r, ok := c.added[k]; ok {
+			added = append(added, r)
+		}
+	}
+	return deleted, added
+}
+
+// zoneFromSOA copies the primary's SOA values into our zone settings.
+func zoneFromSOA(zone model.Zone, soa *dns.SOA) model.Zone {
+	zone.Ns = strings.TrimSuffix(soa.Ns, ".")
+	zone.Mbox = strings.TrimSuffix(soa.Mbox, ".")
+	zone.Serial = soa.Serial
+	zone.Refresh = int(soa.Refresh)
+	zone.Retry = int(soa.Retry)
+	zone.Expire = int(soa.Expire)
+	zone.Minimum = int(soa.Minttl)
+	zone.TTL = int(soa.Hdr.Ttl)
+	return zone
+}
+
+// primaryAddr adds the default DNS port to a primary given without one.
+func primaryAddr(primary string) string {
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		return net.JoinHostPort(primary, "53")
+	}
+	return primary
+}
+
+// primaryTsig returns the client secrets, key name and algorithm used to sign
+// requests to a primary with the named key, or empty values when there is none.
+func primaryTsig(keyName string) (map[string]string, string, string, error) {
+	if keyName == "" {
+		return nil, "", "", nil
+	}
+	key, err := lookupTsigKey(keyName)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if key == nil {
+		return nil, "", "", fmt.Errorf("TSIG key %s does not exist", keyName)
+	}
+	name := dns.Fqdn(key.Name)
+	return map[string]string{name: key.Secret}, name, key.Algorithm, nil
+}
+
+// handleNotify accepts NOTIFY messages (RFC 1996) from the primary of a
+// secondary zone and schedules an immediate refresh.
+func handleNotify(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	zone, err := db.GetZone(Ctx, q.Name)
+	if err != nil {
+		logger.Logger.Errorf("db zone lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	if zone == nil || !zone.IsSecondary() || q.Qtype != dns.TypeSOA {
+		RespondWithRcode(w, r, dns.RcodeNotAuth)
+		return
+	}
+	if !fromPrimary(w, r, zone) {
+		logger.Logger.Warnf("ignoring NOTIFY for %s from %s", zone.Name, w.RemoteAddr())
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	writeResponse(w, r, m)
+
+	select {
+	case refreshRequests <- zone.Name:
+		logger.Logger.Infof("NOTIFY for %s from %s, refreshing", zone.Name, w.RemoteAddr())
+	default:
+		logger.Logger.Warnf("refresh queue full, dropping NOTIFY for %s", zone.Name)
+	}
+}
+
+// fromPrimary reports whether a message was sent by the zone's primary: signed
+// with the primary's TSIG key when one is configured, from its address otherwise.
+func fromPrimary(w dns.ResponseWriter, r *dns.Msg, zone *model.Zone) bool {
+	if zone.PrimaryKey != "" {
+		return tsigKeyName(w, r) == strings.ToLower(strings.TrimSuffix(zone.PrimaryKey, "."))
+	}
+
+	host, _, _ := net.SplitHostPort(primaryAddr(zone.Primary))
+	remote := util.AddrOf(w.RemoteAddr())
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap() == remote
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(Ctx, "ip", host)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.Unmap() == remote {
+			return true
+		}
+	}
+	return false
+}
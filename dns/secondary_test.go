@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+func testRRs(t *testing.T, lines ...string) []dns.RR {
+	t.Helper()
+	out := make([]dns.RR, len(lines))
+	for i, s := range lines {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		out[i] = rr
+	}
+	return out
+}
+
+func testSOA(serial int) string {
+	return fmt.Sprintf("example.test. 3600 IN SOA ns.example.test. hostmaster.example.test. %d 7200 900 1209600 60", serial)
+}
+
+// recordKeys formats records for comparison.
+func recordKeys(recs []model.Record) []string {
+	var out []string
+	for _, r := range recs {
+		out = append(out, fmt.Sprintf("%s %d %s %s", r.Domain, r.TTL, r.QType, r.Value))
+	}
+	return out
+}
+
+func checkChanges(t *testing.T, deleted, added []model.Record, wantDeleted, wantAdded []string) {
+	t.Helper()
+	if got := recordKeys(deleted); !slices.Equal(got, wantDeleted) {
+		t.Errorf("deleted %q, want %q", got, wantDeleted)
+	}
+	if got := recordKeys(added); !slices.Equal(got, wantAdded) {
+		t.Errorf("added %q, want %q", got, wantAdded)
+	}
+}
+
+func TestTransferChangesIncremental(t *testing.T) {
+	zone := model.Zone{Name: "example.test", Serial: 1}
+	rrs := testRRs(t,
+		testSOA(3),
+		// 1 -> 2: replace www, change the TTL of mail
+		testSOA(1),
+		"www.example.test. 300 IN A 192.0.2.1",
+		"mail.example.test. 300 IN A 192.0.2.25",
+		testSOA(2),
+		"www.example.test. 300 IN A 192.0.2.2",
+		"mail.example.test. 600 IN A 192.0.2.25",
+		"tmp.example.test. 300 IN TXT \"temporary\"",
+		// 2 -> 3: drop the record added in the previous step, re-add one unchanged
+		testSOA(2),
+		"tmp.example.test. 300 IN TXT \"temporary\"",
+		"ftp.example.test. 300 IN A 192.0.2.21",
+		testSOA(3),
+		"ftp.example.test. 300 IN A 192.0.2.21",
+		"example.test. 300 IN MX 10 mail.example.test.",
+		testSOA(3),
+	)
+
+	deleted, added, err := transferChanges(zone, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(t, deleted, added,
+		[]string{"www.example.test 300 A 192.0.2.1", "mail.example.test 300 A 192.0.2.25"},
+		// Changes are reported in the order the records first appeared
+		[]string{"mail.example.test 600 A 192.0.2.25", "www.example.test 300 A 192.0.2.2", "example.test 300 MX 10 mail.example.test"},
+	)
+}
+
+func TestTransferChangesFull(t *testing.T) {
+	logger.InitLogger("error")
+	zone := model.Zone{Name: "example.test", Serial: 1}
+	current := []model.Record{
+		{Domain: "www.example.test", QType: "A", TTL: 300, Value: "192.0.2.1"},
+		{Domain: "mail.example.test", QType: "A", TTL: 300, Value: "192.0.2.25"},
+		{Domain: "old.example.test", QType: "CNAME", TTL: 300, Value: "www.example.test"},
+	}
+	rrs := testRRs(t,
+		testSOA(2),
+		"WWW.example.test. 300 IN A 192.0.2.1",
+		"mail.example.test. 600 IN A 192.0.2.25",
+		"example.test. 300 IN NS ns.example.test.",
+		// Types we cannot store are skipped
+		"example.test. 300 IN HINFO \"cpu\" \"os\"",
+		testSOA(2),
+	)
+
+	deleted, added := fullTransferChanges(zone, current, rrs)
+	checkChanges(t, deleted, added,
+		[]string{"mail.example.test 300 A 192.0.2.25", "old.example.test 300 CNAME www.example.test"},
+		[]string{"mail.example.test 600 A 192.0.2.25", "example.test 300 NS ns.example.test"},
+	)
+}
+
+func TestTransferChangesCurrent(t *testing.T) {
+	zone := model.Zone{Name: "example.test", Serial: 2}
+
+	// An IXFR answered with a lone SOA means we are already current
+	deleted, added, err := transferChanges(zone, testRRs(t, testSOA(2)))
+	if err != nil || deleted != nil || added != nil {
+		t.Errorf("got %v, %v, %v, want no changes", deleted, added, err)
+	}
+
+	if _, _, err := transferChanges(zone, testRRs(t, "www.example.test. 300 IN A 192.0.2.1", testSOA(2))); err == nil {
+		t.Error("expected an error for a transfer not starting with SOA")
+	}
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/util"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate/key pair from disk and reloads it when
+// either file changes, so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// load reads the certificate and key. Callers hold mu, except during construction.
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// filesModTime returns the most recent modification time of the two files.
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. A certificate that fails
+// to reload is logged and the previous one kept in service.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if modTime, err := r.filesModTime(); err != nil || !modTime.Equal(r.modTime) {
+			if err == nil {
+				err = r.load()
+			}
+			if err != nil {
+				logger.Logger.Errorf("failed to reload TLS certificate %s: %v", r.certFile, err)
+			} else {
+				logger.Logger.Infof("reloaded TLS certificate %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// tlsConfig returns a server TLS configuration for the certificate configured
+// in TLS_CERT_FILE and TLS_KEY_FILE, advertising the given ALPN protocols.
+func tlsConfig(protos ...string) (*tls.Config, error) {
+	certFile, keyFile := util.MustGetenv("TLS_CERT_FILE", ""), util.MustGetenv("TLS_KEY_FILE", "")
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set")
+	}
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protos,
+	}, nil
+}
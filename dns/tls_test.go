@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+)
+
+// writeTestCert writes a new self-signed certificate for 127.0.0.1 and its key.
+func writeTestCert(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of files forward, as a renewal would.
+func touch(t *testing.T, d time.Duration, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		mt := time.Now().Add(d)
+		if err := os.Chtimes(f, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedCert returns the DER certificate r serves once its check interval has passed.
+func servedCert(t *testing.T, r *certReloader) []byte {
+	t.Helper()
+	r.mu.Lock()
+	r.checked = time.Now().Add(-certCheckInterval)
+	r.mu.Unlock()
+	c, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	logger.InitLogger("error")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := first.Certificate[0]
+
+	// Unchanged files are not reloaded
+	if got := servedCert(t, r); !bytes.Equal(got, original) {
+		t.Error("certificate changed without a renewal")
+	}
+
+	// A renewal is picked up at the next check
+	writeTestCert(t, certFile, keyFile)
+	touch(t, time.Minute, certFile, keyFile)
+	renewed := servedCert(t, r)
+	if bytes.Equal(renewed, original) {
+		t.Fatal("renewed certificate not served")
+	}
+
+	// A renewal that cannot be loaded keeps the previous certificate in service
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, 2*time.Minute, keyFile)
+	if got := servedCert(t, r); !bytes.Equal(got, renewed) {
+		t.Error("broken renewal replaced the certificate in service")
+	}
+}
+
+func TestCertReloaderWaitsForInterval(t *testing.T) {
+	logger.InitLogger("error")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := r.cert.Certificate[0]
+
+	writeTestCert(t, certFile, keyFile)
+	touch(t, time.Minute, certFile, keyFile)
+	c, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Certificate[0], original) {
+		t.Error("files were checked before certCheckInterval passed")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_KEY_FILE", "")
+	if _, err := tlsConfig("dot"); err == nil {
+		t.Error("expected an error without TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	cfg, err := tlsConfig("dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != "dot" {
+		t.Errorf("got min version %#x and protocols %v, want TLS 1.2 and dot", cfg.MinVersion, cfg.NextProtos)
+	}
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+// tsigCacheTTL is how long TSIG keys are kept in memory before re-reading Postgres.
+const tsigCacheTTL = time.Minute
+
+type cachedTsigKey struct {
+	key    *model.TsigKey
+	loaded time.Time
+}
+
+// tsigCache maps a canonical key name to its *cachedTsigKey.
+var tsigCache sync.Map
+
+// tsigProvider verifies and signs TSIG messages with the keys stored in Postgres.
+type tsigProvider struct{}
+
+func (tsigProvider) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	key, err := lookupTsigKey(t.Hdr.Name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, dns.ErrSecret
+	}
+	if dns.CanonicalName(t.Algorithm) != key.Algorithm {
+		return nil, dns.ErrKeyAlg
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	var h func() hash.Hash
+	switch key.Algorithm {
+	case dns.HmacSHA1:
+		h = sha1.New
+	case dns.HmacSHA224:
+		h = sha256.New224
+	case dns.HmacSHA256:
+		h = sha256.New
+	case dns.HmacSHA384:
+		h = sha512.New384
+	case dns.HmacSHA512:
+		h = sha512.New
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (p tsigProvider) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := p.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, got) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// lookupTsigKey returns the TSIG key with the given name, or nil if unknown.
+func lookupTsigKey(name string) (*model.TsigKey, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if v, ok := tsigCache.Load(name); ok {
+		if c := v.(*cachedTsigKey); time.Since(c.loaded) < tsigCacheTTL {
+			return c.key, nil
+		}
+	}
+
+	key, err := db.GetTsigKey(Ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	tsigCache.Store(name, &cachedTsigKey{key: key, loaded: time.Now()})
+	return key, nil
+}
+
+// tsigKeyName returns the name of the key that validly signed req, or "" when
+// the request was not signed or its signature did not verify.
+func tsigKeyName(w dns.ResponseWriter, req *dns.Msg) string {
+	t := req.IsTsig()
+	if t == nil || w.TsigStatus() != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(t.Hdr.Name, "."))
+}
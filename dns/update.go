@@ -0,0 +1,345 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/extremtechniker/godns/cache"
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/notify"
+	"github.com/miekg/dns"
+)
+
+// acceptMsg extends the default accept policy with UPDATE messages, whose
+// sections may carry any number of RRs.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	if opcode := int(dh.Bits>>11) & 0xF; opcode == dns.OpcodeUpdate && dh.Bits&(1<<15) == 0 {
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// handleUpdate applies a dynamic update (RFC 2136). Updates must be signed with
+// the zone's update key; prerequisites are evaluated and the changes applied in
+// a single transaction, so either all of them take effect or none do.
+func handleUpdate(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	if len(r.Question) != 1 || q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		RespondWithRcode(w, r, dns.RcodeFormatError)
+		return
+	}
+
+	zone, err := db.GetZone(Ctx, q.Name)
+	if err != nil {
+		logger.Logger.Errorf("db zone lookup error: %v", err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	if zone == nil {
+		RespondWithRcode(w, r, dns.RcodeNotAuth)
+		return
+	}
+	if zone.IsSecondary() || zone.UpdateKey == "" ||
+		tsigKeyName(w, r) != strings.ToLower(strings.TrimSuffix(zone.UpdateKey, ".")) {
+		logger.Logger.Warnf("refusing update of %s from %s", zone.Name, w.RemoteAddr())
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		return
+	}
+
+	rcode, changed, err := applyUpdate(zone, r)
+	if err != nil {
+		logger.Logger.Errorf("failed to apply update to %s: %v", zone.Name, err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+	RespondWithRcode(w, r, rcode)
+	if len(changed) == 0 {
+		return
+	}
+
+	logger.Logger.Infof("applied update to %s from %s (%d records changed)", zone.Name, w.RemoteAddr(), len(changed))
+	if err := cache.Invalidate(Ctx, changed); err != nil {
+		logger.Logger.Errorf("failed to invalidate cache for %s: %v", zone.Name, err)
+	}
+	go func() {
+		if err := notify.Zone(Ctx, zone.Name); err != nil {
+			logger.Logger.Errorf("failed to notify secondaries: %v", err)
+		}
+	}()
+}
+
+// applyUpdate checks the prerequisite section, prescans the update section and
+// applies it. It returns the rcode for the client and the records that changed.
+func applyUpdate(zone *model.Zone, r *dns.Msg) (int, []model.Record, error) {
+	u, err := db.BeginUpdate(Ctx, zone.Name)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer u.Rollback(Ctx)
+
+	if rcode, err := checkPrerequisites(u, zone, r.Answer); rcode != dns.RcodeSuccess || err != nil {
+		return rcode, nil, err
+	}
+	for _, rr := range r.Ns {
+		if rcode, err := prescanUpdate(zone, rr); rcode != dns.RcodeSuccess || err != nil {
+			return rcode, nil, err
+		}
+	}
+	for _, rr := range r.Ns {
+		if err := applyUpdateRR(u, zone, rr); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	changed, err := u.Commit(Ctx)
+	return dns.RcodeSuccess, changed, err
+}
+
+// checkPrerequisites evaluates the prerequisite section (RFC 2136 section 3.2).
+func checkPrerequisites(u *db.Update, zone *model.Zone, prereqs []dns.RR) (int, error) {
+	type rrsetKey struct {
+		name  string
+		rtype uint16
+	}
+	required := map[rrsetKey][]dns.RR{}
+
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError, nil
+		}
+		name := updateName(h.Name)
+		if ok, err := inZone(zone, name); !ok || err != nil {
+			return dns.RcodeNotZone, err
+		}
+
+		switch h.Class {
+		case dns.ClassANY, dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError, nil
+			}
+			exists, err := rrsetExists(u, zone, name, h.Rrtype)
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case h.Class == dns.ClassANY && !exists && h.Rrtype == dns.TypeANY:
+				return dns.RcodeNameError, nil
+			case h.Class == dns.ClassANY && !exists:
+				return dns.RcodeNXRrset, nil
+			case h.Class == dns.ClassNONE && exists && h.Rrtype == dns.TypeANY:
+				return dns.RcodeYXDomain, nil
+			case h.Class == dns.ClassNONE && exists:
+				return dns.RcodeYXRrset, nil
+			}
+		case dns.ClassINET:
+			k := rrsetKey{name, h.Rrtype}
+			required[k] = append(required[k], rr)
+		default:
+			return dns.RcodeFormatError, nil
+		}
+	}
+
+	// Value dependent prerequisites require the stored RRset to match exactly
+	for k, want := range required {
+		have, err := rrset(u, zone, k.name, k.rtype)
+		if err != nil {
+			return 0, err
+		}
+		if !sameRRs(have, want) || !sameRRs(want, have) {
+			return dns.RcodeNXRrset, nil
+		}
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// prescanUpdate checks a single update RR for errors before anything is applied
+// (RFC 2136 section 3.4.1).
+func prescanUpdate(zone *model.Zone, rr dns.RR) (int, error) {
+	h := rr.Header()
+	if ok, err := inZone(zone, updateName(h.Name)); !ok || err != nil {
+		return dns.RcodeNotZone, err
+	}
+
+	switch h.Class {
+	case dns.ClassINET:
+		if metaType(h.Rrtype) {
+			return dns.RcodeFormatError, nil
+		}
+		if _, ok := model.RecordFromRR(rr); !ok && h.Rrtype != dns.TypeSOA {
+			logger.Logger.Debugf("refusing update with unsupported type %s", dns.Type(h.Rrtype))
+			return dns.RcodeRefused, nil
+		}
+	case dns.ClassANY:
+		if h.Ttl != 0 || h.Rdlength != 0 || (metaType(h.Rrtype) && h.Rrtype != dns.TypeANY) {
+			return dns.RcodeFormatError, nil
+		}
+	case dns.ClassNONE:
+		if h.Ttl != 0 || metaType(h.Rrtype) {
+			return dns.RcodeFormatError, nil
+		}
+	default:
+		return dns.RcodeFormatError, nil
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// applyUpdateRR applies a single prescanned update RR (RFC 2136 section 3.4.2).
+// The SOA is generated from the zone and the apex NS set is never emptied.
+func applyUpdateRR(u *db.Update, zone *model.Zone, rr dns.RR) error {
+	h := rr.Header()
+	name := updateName(h.Name)
+	apex := name == zone.Name
+	qtype := dns.Type(h.Rrtype).String()
+
+	switch h.Class {
+	case dns.ClassINET:
+		if h.Rrtype == dns.TypeSOA {
+			return nil
+		}
+		rec, _ := model.RecordFromRR(rr)
+		rec.Domain = name
+
+		existing, err := u.Records(Ctx, name, "")
+		if err != nil {
+			return err
+		}
+		for _, e := range existing {
+			// A CNAME cannot coexist with other data: it replaces an existing CNAME
+			// but is ignored next to other types, and vice versa
+			if (rec.QType == "CNAME") != (e.QType == "CNAME") {
+				return nil
+			}
+		}
+		if rec.QType == "CNAME" {
+			if err := u.Delete(Ctx, name, "CNAME", ""); err != nil {
+				return err
+			}
+		}
+		return u.Add(Ctx, rec)
+
+	case dns.ClassANY:
+		if h.Rrtype != dns.TypeANY {
+			if apex && (h.Rrtype == dns.TypeSOA || h.Rrtype == dns.TypeNS) {
+				return nil
+			}
+			return u.Delete(Ctx, name, qtype, "")
+		}
+		if !apex {
+			return u.Delete(Ctx, name, "", "")
+		}
+		existing, err := u.Records(Ctx, name, "")
+		if err != nil {
+			return err
+		}
+		for _, e := range existing {
+			if e.QType != "NS" {
+				if err := u.Delete(Ctx, name, e.QType, e.Value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case dns.ClassNONE:
+		if h.Rrtype == dns.TypeSOA {
+			return nil
+		}
+		existing, err := u.Records(Ctx, name, qtype)
+		if err != nil {
+			return err
+		}
+		if apex && h.Rrtype == dns.TypeNS && len(existing) <= 1 {
+			return nil
+		}
+		target := dns.Copy(rr)
+		target.Header().Class = dns.ClassINET
+		for _, e := range existing {
+			if stored, err := e.RR(); err == nil && dns.IsDuplicate(stored, target) {
+				if err := u.Delete(Ctx, name, e.QType, e.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// rrsetExists reports whether name has records of type rtype, or any records for TypeANY.
+func rrsetExists(u *db.Update, zone *model.Zone, name string, rtype uint16) (bool, error) {
+	if name == zone.Name && (rtype == dns.TypeSOA || rtype == dns.TypeANY) {
+		return true, nil
+	}
+	qtype := ""
+	if rtype != dns.TypeANY {
+		qtype = dns.Type(rtype).String()
+	}
+	recs, err := u.Records(Ctx, name, qtype)
+	return len(recs) > 0, err
+}
+
+// rrset returns the RRset of type rtype at name, including the generated apex SOA.
+func rrset(u *db.Update, zone *model.Zone, name string, rtype uint16) ([]dns.RR, error) {
+	if name == zone.Name && rtype == dns.TypeSOA {
+		return []dns.RR{zone.SOA()}, nil
+	}
+	recs, err := u.Records(Ctx, name, dns.Type(rtype).String())
+	if err != nil {
+		return nil, err
+	}
+	var out []dns.RR
+	for _, r := range recs {
+		if rr, err := r.RR(); err == nil {
+			out = append(out, rr)
+		}
+	}
+	return out, nil
+}
+
+// sameRRs reports whether every RR in a has an equal RR in b, ignoring TTLs.
+func sameRRs(a, b []dns.RR) bool {
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if dns.IsDuplicate(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// inZone reports whether name belongs to zone rather than to one of our more specific zones.
+func inZone(zone *model.Zone, name string) (bool, error) {
+	if !zone.Contains(name) {
+		return false, nil
+	}
+	owner, err := db.FindZone(Ctx, name)
+	if err != nil || owner == nil {
+		return false, err
+	}
+	return owner.Name == zone.Name, nil
+}
+
+// metaType reports whether t is a query or meta type that cannot be stored.
+func metaType(t uint16) bool {
+	switch t {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB,
+		dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	}
+	return false
+}
+
+// updateName converts an owner name from an update into the form records are stored under.
+func updateName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// views caches the views, ordered by priority.
+var views = newCachedLoader(func([]model.View) ([]model.View, error) {
+	return db.FetchViews(Ctx)
+})
+
+// selectView returns the name of the first view the client of req belongs to,
+// or "" when it belongs to none and is served the untagged records only.
+func selectView(w dns.ResponseWriter, req *dns.Msg) (string, error) {
+	all, err := views.get()
+	if err != nil || len(all) == 0 {
+		return "", err
+	}
+
+	remote, local := util.AddrOf(w.RemoteAddr()), util.AddrOf(w.LocalAddr())
+	key := tsigKeyName(w, req)
+	// Views are ordered by priority
+	for _, v := range all {
+		if v.Matches(remote, local, key) {
+			return v.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// checkViewListeners warns about views matching on a listener address when the
+// daemon listens on a wildcard address: UDP queries received there only know
+// the wildcard as their local address, so such views never match them.
+func checkViewListeners(listen []string) {
+	if !wildcardListen(listen) {
+		return
+	}
+	all, err := views.get()
+	if err != nil {
+		logger.Logger.Errorf("failed to load views: %v", err)
+		return
+	}
+	for _, v := range all {
+		if len(v.Listen) > 0 {
+			logger.Logger.Warnf("view %s matches listener addresses %v, but UDP queries to the wildcard DNS_LISTEN "+
+				"address cannot be told apart; list those addresses in DNS_LISTEN instead", v.Name, v.Listen)
+		}
+	}
+}
+
+// wildcardListen reports whether any of the listen addresses is a wildcard.
+func wildcardListen(listen []string) bool {
+	for _, addr := range listen {
+		host, _, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			continue
+		}
+		if ip, err := netip.ParseAddr(host); host == "" || err == nil && ip.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
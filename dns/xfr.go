@@ -0,0 +1,179 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// xfrBatchSize is the number of RRs sent per message of a zone transfer.
+const xfrBatchSize = 100
+
+// handleTransfer answers AXFR (RFC 5936) and IXFR (RFC 1995) requests for zones
+// we are primary for. Transfers are only allowed to clients matching the zone's
+// transfer ACL and/or signing with its transfer TSIG key.
+func handleTransfer(w dns.ResponseWriter, r *dns.Msg, zone *model.Zone) {
+	q := r.Question[0]
+	if !strings.EqualFold(dns.Fqdn(zone.Name), q.Name) {
+		RespondWithRcode(w, r, dns.RcodeNotAuth)
+		return
+	}
+	if !transferAllowed(w, r, zone) {
+		logger.Logger.Warnf("refused %s of %s to %s", dns.TypeToString[q.Qtype], zone.Name, w.RemoteAddr())
+		RespondWithRcode(w, r, dns.RcodeRefused)
+		return
+	}
+
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	if !tcp {
+		// AXFR is TCP only; IXFR over UDP gets the current SOA so the client retries over TCP
+		if q.Qtype == dns.TypeAXFR {
+			RespondWithRcode(w, r, dns.RcodeFormatError)
+			return
+		}
+		RespondWithRecords(w, r, zone, nil, dns.Question{Name: q.Name, Qtype: dns.TypeSOA, Qclass: q.Qclass})
+		return
+	}
+
+	var rrs []dns.RR
+	var err error
+	if q.Qtype == dns.TypeIXFR {
+		rrs, err = incrementalTransfer(r, zone)
+	}
+	if err == nil && rrs == nil {
+		rrs, err = fullTransfer(zone)
+	}
+	if err != nil {
+		logger.Logger.Errorf("zone transfer of %s failed: %v", zone.Name, err)
+		RespondWithRcode(w, r, dns.RcodeServerFailure)
+		return
+	}
+
+	ch := make(chan *dns.Envelope)
+	tr := new(dns.Transfer)
+	go func() {
+		defer close(ch)
+		for len(rrs) > 0 {
+			n := min(xfrBatchSize, len(rrs))
+			ch <- &dns.Envelope{RR: rrs[:n]}
+			rrs = rrs[n:]
+		}
+	}()
+	if err := tr.Out(w, r, ch); err != nil {
+		logger.Logger.Errorf("zone transfer of %s to %s failed: %v", zone.Name, w.RemoteAddr(), err)
+		for range ch {
+		}
+		return
+	}
+	logger.Logger.Infof("%s of %s (serial %d) sent to %s", dns.TypeToString[q.Qtype], zone.Name, zone.Serial, w.RemoteAddr())
+}
+
+// transferAllowed applies the zone's transfer ACL and TSIG requirement.
+// Zones without either are never transferred.
+func transferAllowed(w dns.ResponseWriter, r *dns.Msg, zone *model.Zone) bool {
+	if len(zone.TransferACL) == 0 && zone.TransferKey == "" {
+		return false
+	}
+	if len(zone.TransferACL) > 0 && !util.PrefixesContain(zone.TransferACL, util.AddrOf(w.RemoteAddr())) {
+		return false
+	}
+	if zone.TransferKey != "" && tsigKeyName(w, r) != strings.ToLower(strings.TrimSuffix(zone.TransferKey, ".")) {
+		return false
+	}
+	return true
+}
+
+// fullTransfer returns the whole zone framed by its SOA.
+func fullTransfer(zone *model.Zone) ([]dns.RR, error) {
+	recs, err := db.FetchZoneRecords(Ctx, zone.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	soa := zone.SOA()
+	rrs := []dns.RR{soa}
+	rrs = append(rrs, transferRRs(recs)...)
+	return append(rrs, soa), nil
+}
+
+// incrementalTransfer builds an IXFR response from the zone journal. It returns
+// nil when the journal cannot bridge the client's serial, so the caller falls
+// back to a full transfer.
+func incrementalTransfer(r *dns.Msg, zone *model.Zone) ([]dns.RR, error) {
+	var clientSOA *dns.SOA
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			clientSOA = soa
+		}
+	}
+	if clientSOA == nil {
+		return nil, nil
+	}
+
+	current := zone.SOA()
+	if clientSOA.Serial == zone.Serial {
+		return []dns.RR{current}, nil
+	}
+
+	changes, err := db.FetchJournal(Ctx, zone.Name, clientSOA.Serial)
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+
+	soaAt := func(serial uint32) *dns.SOA {
+		soa := zone.SOA()
+		soa.Serial = serial
+		return soa
+	}
+
+	// Each step is: old SOA, deleted RRs, new SOA, added RRs
+	rrs := []dns.RR{current}
+	for i := 0; i < len(changes); {
+		from, to := changes[i].FromSerial, changes[i].ToSerial
+		if i > 0 && from != changes[i-1].ToSerial {
+			return nil, nil
+		}
+
+		var deleted, added []model.Record
+		for ; i < len(changes) && changes[i].FromSerial == from && changes[i].ToSerial == to; i++ {
+			if changes[i].Op == model.ChangeDelete {
+				deleted = append(deleted, changes[i].Record)
+			} else {
+				added = append(added, changes[i].Record)
+			}
+		}
+
+		rrs = append(rrs, soaAt(from))
+		rrs = append(rrs, transferRRs(deleted)...)
+		rrs = append(rrs, soaAt(to))
+		rrs = append(rrs, transferRRs(added)...)
+	}
+	if changes[len(changes)-1].ToSerial != zone.Serial {
+		return nil, nil
+	}
+
+	return append(rrs, current), nil
+}
+
+// transferRRs converts records for a zone transfer. ALIAS records only exist
+// in our data model and cannot be represented on the wire, so they are skipped.
+func transferRRs(recs []model.Record) []dns.RR {
+	var out []dns.RR
+	for _, r := range recs {
+		if strings.EqualFold(r.QType, "ALIAS") {
+			continue
+		}
+		rr, err := r.RR()
+		if err != nil {
+			logger.Logger.Warnf("skipping invalid %s record for %s: %v", r.QType, r.Domain, err)
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
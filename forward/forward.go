@@ -0,0 +1,208 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultTimeout applies to upstreams without a ?timeout= parameter.
+	defaultTimeout = 2 * time.Second
+	// maxFailures consecutive failures mark an upstream unhealthy.
+	maxFailures = 3
+)
+
+// HealthInterval is how often upstreams are probed.
+const HealthInterval = 10 * time.Second
+
+// Upstream is a recursive resolver queries are forwarded to.
+type Upstream struct {
+	Addr string
+	// Net is "udp", "tcp" or "tcp-tls".
+	Net string
+	// ServerName is the name verified in the certificate of DoT upstreams.
+	ServerName string
+	Timeout    time.Duration
+
+	failures atomic.Int32
+}
+
+// ParseUpstream parses an upstream of the form [udp|tcp|tls://]host[:port][?timeout=2s&name=tls-name].
+// Without a scheme the upstream is queried over UDP, and the port defaults to 53 (853 for tls).
+func ParseUpstream(s string) (*Upstream, error) {
+	if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	uri, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", s, err)
+	}
+
+	u := &Upstream{Net: uri.Scheme, Timeout: defaultTimeout}
+	port := "53"
+	switch uri.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		u.Net, port = "tcp-tls", "853"
+	default:
+		return nil, fmt.Errorf("invalid upstream %q: unsupported protocol %q", s, uri.Scheme)
+	}
+	if uri.Hostname() == "" {
+		return nil, fmt.Errorf("invalid upstream %q: missing host", s)
+	}
+	if uri.Port() != "" {
+		port = uri.Port()
+	}
+	u.Addr = net.JoinHostPort(uri.Hostname(), port)
+
+	u.ServerName = uri.Hostname()
+	if name := uri.Query().Get("name"); name != "" {
+		u.ServerName = name
+	}
+	if t := uri.Query().Get("timeout"); t != "" {
+		if u.Timeout, err = time.ParseDuration(t); err != nil || u.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid upstream %q: bad timeout %q", s, t)
+		}
+	}
+	return u, nil
+}
+
+func (u *Upstream) String() string {
+	scheme := u.Net
+	if scheme == "tcp-tls" {
+		scheme = "tls"
+	}
+	return scheme + "://" + u.Addr
+}
+
+// Healthy reports whether the upstream answered recently.
+func (u *Upstream) Healthy() bool {
+	return u.failures.Load() < maxFailures
+}
+
+// Exchange sends req to the upstream, retrying truncated UDP answers over TCP.
+func (u *Upstream) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: u.Net, Timeout: u.Timeout}
+	if u.Net == "tcp-tls" {
+		c.TLSConfig = &tls.Config{ServerName: u.ServerName}
+	}
+
+	resp, _, err := c.ExchangeContext(ctx, req, u.Addr)
+	if err == nil && resp.Truncated && u.Net == "udp" {
+		c.Net = "tcp"
+		resp, _, err = c.ExchangeContext(ctx, req, u.Addr)
+	}
+	if err != nil {
+		if u.failures.Add(1) == maxFailures {
+			logger.Logger.Warnf("upstream %s is unhealthy: %v", u, err)
+		}
+		return nil, fmt.Errorf("upstream %s: %w", u, err)
+	}
+	if u.failures.Swap(0) >= maxFailures {
+		logger.Logger.Infof("upstream %s is healthy again", u)
+	}
+	return resp, nil
+}
+
+// Forwarder sends queries to a list of upstreams, failing over between them.
+type Forwarder struct {
+	Upstreams []*Upstream
+}
+
+// New returns a forwarder for the given upstream specifications (see ParseUpstream).
+func New(specs []string) (*Forwarder, error) {
+	f := &Forwarder{}
+	for _, s := range specs {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		u, err := ParseUpstream(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Upstreams = append(f.Upstreams, u)
+	}
+	if len(f.Upstreams) == 0 {
+		return nil, errors.New("no upstreams configured")
+	}
+	return f, nil
+}
+
+// String lists the upstreams of the forwarder.
+func (f *Forwarder) String() string {
+	s := make([]string, len(f.Upstreams))
+	for i, u := range f.Upstreams {
+		s[i] = u.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// Exchange forwards req to the first healthy upstream, moving on to the next
+// one when an upstream fails or answers SERVFAIL/REFUSED. Unhealthy upstreams
+// are only tried once every healthy one failed.
+func (f *Forwarder) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	var order []*Upstream
+	for _, healthy := range []bool{true, false} {
+		for _, u := range f.Upstreams {
+			if u.Healthy() == healthy {
+				order = append(order, u)
+			}
+		}
+	}
+
+	var last *dns.Msg
+	var errs []error
+	for _, u := range order {
+		resp, err := u.Exchange(ctx, req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+			last = resp
+			continue
+		}
+		return resp, nil
+	}
+	if last != nil {
+		return last, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// HealthCheck probes the upstreams every HealthInterval until ctx is done.
+func (f *Forwarder) HealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Probe(ctx)
+		}
+	}
+}
+
+// Probe sends a root NS query to every upstream in the background, so failed
+// upstreams are brought back once they answer again.
+func (f *Forwarder) Probe(ctx context.Context) {
+	for _, u := range f.Upstreams {
+		go func() {
+			probe := new(dns.Msg)
+			probe.SetQuestion(".", dns.TypeNS)
+			_, _ = u.Exchange(ctx, probe)
+		}()
+	}
+}
@@ -0,0 +1,192 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/extremtechniker/godns/logger"
+	"github.com/miekg/dns"
+)
+
+// startUpstream serves UDP on a free local port, answering every query with
+// rcode and a TXT record naming the upstream.
+func startUpstream(t *testing.T, name string, rcode int) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+			m.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{name}}}
+			_ = w.WriteMsg(m)
+		})}
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// downUpstream returns a local address nothing listens on.
+func downUpstream(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+	return addr
+}
+
+func exchange(t *testing.T, f *Forwarder) (string, int, error) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeTXT)
+	resp, err := f.Exchange(context.Background(), req)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resp.Answer) == 0 {
+		return "", resp.Rcode, nil
+	}
+	return resp.Answer[0].(*dns.TXT).Txt[0], resp.Rcode, nil
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		spec    string
+		addr    string
+		net     string
+		name    string
+		timeout time.Duration
+	}{
+		{"1.1.1.1", "1.1.1.1:53", "udp", "1.1.1.1", defaultTimeout},
+		{"tcp://10.0.0.53:5353", "10.0.0.53:5353", "tcp", "10.0.0.53", defaultTimeout},
+		{"tls://9.9.9.9?name=dns.quad9.net", "9.9.9.9:853", "tcp-tls", "dns.quad9.net", defaultTimeout},
+		{"[2606:4700::1111]:53?timeout=500ms", "[2606:4700::1111]:53", "udp", "2606:4700::1111", 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			u, err := ParseUpstream(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Addr != tt.addr || u.Net != tt.net || u.ServerName != tt.name || u.Timeout != tt.timeout {
+				t.Errorf("got %s %s %s %s, want %s %s %s %s", u.Addr, u.Net, u.ServerName, u.Timeout, tt.addr, tt.net, tt.name, tt.timeout)
+			}
+		})
+	}
+
+	for _, spec := range []string{"https://1.1.1.1", "udp://:53", "1.1.1.1?timeout=soon", "1.1.1.1?timeout=-1s"} {
+		if _, err := ParseUpstream(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+	if _, err := New([]string{" ", ""}); err == nil {
+		t.Error("expected an error without upstreams")
+	}
+}
+
+func TestExchangeUsesFirstUpstream(t *testing.T) {
+	logger.InitLogger("error")
+	f, err := New([]string{startUpstream(t, "first", dns.RcodeSuccess), startUpstream(t, "second", dns.RcodeSuccess)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if got, _, err := exchange(t, f); err != nil || got != "first" {
+			t.Fatalf("answered by %q (%v), want first", got, err)
+		}
+	}
+}
+
+func TestExchangeFailover(t *testing.T) {
+	logger.InitLogger("error")
+	down := downUpstream(t) + "?timeout=200ms"
+	f, err := New([]string{down, startUpstream(t, "backup", dns.RcodeSuccess)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range maxFailures {
+		if got, _, err := exchange(t, f); err != nil || got != "backup" {
+			t.Fatalf("query %d answered by %q (%v), want backup", i, got, err)
+		}
+	}
+	if f.Upstreams[0].Healthy() {
+		t.Errorf("upstream still healthy after %d failures", maxFailures)
+	}
+	if !f.Upstreams[1].Healthy() {
+		t.Error("backup upstream marked unhealthy")
+	}
+}
+
+func TestExchangeSkipsServerFailures(t *testing.T) {
+	logger.InitLogger("error")
+	f, err := New([]string{startUpstream(t, "refusing", dns.RcodeRefused), startUpstream(t, "working", dns.RcodeSuccess)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := exchange(t, f); err != nil || got != "working" {
+		t.Errorf("answered by %q (%v), want working", got, err)
+	}
+
+	// When every upstream fails, the last answer is returned
+	f, err = New([]string{startUpstream(t, "refusing", dns.RcodeRefused), startUpstream(t, "failing", dns.RcodeServerFailure)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, rcode, err := exchange(t, f); err != nil || got != "failing" || rcode != dns.RcodeServerFailure {
+		t.Errorf("got %q %s (%v), want the SERVFAIL of the last upstream", got, dns.RcodeToString[rcode], err)
+	}
+
+	// Without any answer the errors are reported
+	f, err = New([]string{downUpstream(t) + "?timeout=200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := exchange(t, f); err == nil {
+		t.Error("expected an error when no upstream answers")
+	}
+}
+
+func TestExchangePrefersHealthyUpstreams(t *testing.T) {
+	logger.InitLogger("error")
+	f, err := New([]string{startUpstream(t, "first", dns.RcodeSuccess), startUpstream(t, "second", dns.RcodeSuccess)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Upstreams[0].failures.Store(maxFailures)
+	if got, _, err := exchange(t, f); err != nil || got != "second" {
+		t.Errorf("answered by %q (%v), want the healthy second upstream", got, err)
+	}
+
+	// A successful probe brings the upstream back
+	f.Probe(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for !f.Upstreams[0].Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("upstream not healthy again after a successful probe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, _, err := exchange(t, f); err != nil || got != "first" {
+		t.Errorf("answered by %q (%v), want first again", got, err)
+	}
+}
+
+func TestForwarderString(t *testing.T) {
+	f, err := New([]string{"1.1.1.1", "tls://9.9.9.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "udp://1.1.1.1:53,tls://9.9.9.9:853"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package geo
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/extremtechniker/godns/util"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Region names derived from a MaxMind database. Regions from CSV files are used as-is.
+const (
+	CountryPrefix   = "country:"
+	ContinentPrefix = "continent:"
+)
+
+// Location is where a client address is located.
+type Location struct {
+	// Regions lists the regions of the address, most specific first: the CSV
+	// region, then the country and the continent.
+	Regions []string
+	// Bits is the prefix length of the most specific database network the
+	// address was looked up in, 0 when unknown.
+	Bits int
+}
+
+// Key identifies the location in cache keys, "-" when no region is known.
+func (l Location) Key() string {
+	if len(l.Regions) == 0 {
+		return "-"
+	}
+	return strings.Join(l.Regions, ",")
+}
+
+// ParseKey is the inverse of Location.Key, without the prefix length.
+func ParseKey(key string) Location {
+	if key == "-" || key == "" {
+		return Location{}
+	}
+	return Location{Regions: strings.Split(key, ",")}
+}
+
+// DB maps client addresses to regions, from MaxMind (.mmdb) country databases
+// and CSV files of "cidr,region" lines.
+type DB struct {
+	mmdb []*maxminddb.Reader
+	// networks holds the CSV networks by prefix length, longest first.
+	networks []map[netip.Prefix]string
+	bits     []int
+}
+
+// mmdbRecord holds the fields read from MaxMind country and city databases.
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// Open loads the given databases; files ending in .mmdb are read as MaxMind
+// databases and everything else as CSV.
+func Open(paths []string) (*DB, error) {
+	db := &DB{}
+	byBits := map[int]map[netip.Prefix]string{}
+	for _, p := range paths {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(p), ".mmdb") {
+			r, err := maxminddb.Open(p)
+			if err != nil {
+				return nil, fmt.Errorf("geo database %s: %w", p, err)
+			}
+			db.mmdb = append(db.mmdb, r)
+			continue
+		}
+		if err := loadCSV(p, byBits); err != nil {
+			return nil, fmt.Errorf("geo database %s: %w", p, err)
+		}
+	}
+	if len(db.mmdb) == 0 && len(byBits) == 0 {
+		return nil, errors.New("no geo databases configured")
+	}
+
+	for bits := 128; bits >= 0; bits-- {
+		if n, ok := byBits[bits]; ok {
+			db.networks = append(db.networks, n)
+			db.bits = append(db.bits, bits)
+		}
+	}
+	return db, nil
+}
+
+// loadCSV reads "cidr,region" lines, skipping blank lines and # comments.
+// IPv4 networks are stored as IPv4-mapped IPv6 prefixes.
+func loadCSV(path string, byBits map[int]map[netip.Prefix]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := util.ParsePrefix(fields[0])
+		if err != nil {
+			line, _ := r.FieldPos(0)
+			return fmt.Errorf("line %d: invalid network %q", line, fields[0])
+		}
+		region := strings.ToLower(strings.TrimSpace(fields[1]))
+		if region == "" {
+			line, _ := r.FieldPos(1)
+			return fmt.Errorf("line %d: missing region", line)
+		}
+
+		p = mapped(p)
+		if byBits[p.Bits()] == nil {
+			byBits[p.Bits()] = map[netip.Prefix]string{}
+		}
+		byBits[p.Bits()][p] = region
+	}
+}
+
+// mapped converts IPv4 prefixes to their IPv4-mapped IPv6 form so both
+// families share a single table.
+func mapped(p netip.Prefix) netip.Prefix {
+	if !p.Addr().Is4() {
+		return p
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+}
+
+// Locate returns the regions of addr.
+func (db *DB) Locate(addr netip.Addr) Location {
+	var loc Location
+	if !addr.IsValid() {
+		return loc
+	}
+	addr = addr.Unmap()
+
+	// Longest matching CSV network
+	a16 := netip.AddrFrom16(addr.As16())
+	for i, n := range db.networks {
+		bits := db.bits[i]
+		if addr.Is4() && bits < 96 {
+			break
+		}
+		p, _ := a16.Prefix(bits)
+		if region, ok := n[p]; ok {
+			loc.Regions = append(loc.Regions, region)
+			loc.Bits = familyBits(addr, bits)
+			break
+		}
+	}
+
+	for _, r := range db.mmdb {
+		var rec mmdbRecord
+		network, ok, err := r.LookupNetwork(net.IP(addr.AsSlice()), &rec)
+		if err != nil {
+			continue
+		}
+		if network != nil {
+			ones, _ := network.Mask.Size()
+			loc.Bits = max(loc.Bits, ones)
+		}
+		if !ok {
+			continue
+		}
+		country := rec.Country.ISOCode
+		if country == "" {
+			country = rec.RegisteredCountry.ISOCode
+		}
+		if country != "" {
+			loc.Regions = append(loc.Regions, CountryPrefix+strings.ToLower(country))
+		}
+		if rec.Continent.Code != "" {
+			loc.Regions = append(loc.Regions, ContinentPrefix+strings.ToLower(rec.Continent.Code))
+		}
+		break
+	}
+	return loc
+}
+
+// familyBits converts a prefix length of the shared IPv6 table back to the family of addr.
+func familyBits(addr netip.Addr, bits int) int {
+	if addr.Is4() {
+		return bits - 96
+	}
+	return bits
+}
@@ -0,0 +1,267 @@
+package geo
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// mmdbValue encodes a value of the MaxMind DB data section format.
+type mmdbValue []byte
+
+// mmdbControl returns the control byte(s) of a value of type typ and size.
+func mmdbControl(typ, size int) []byte {
+	if size >= 29 {
+		panic("mmdbControl: size too large for the test writer")
+	}
+	if typ > 7 {
+		// Extended types are stored in the byte after the control byte
+		return []byte{byte(size), byte(typ - 7)}
+	}
+	return []byte{byte(typ<<5 | size)}
+}
+
+func mmdbString(s string) mmdbValue {
+	return append(mmdbControl(2, len(s)), s...)
+}
+
+func mmdbUint(typ int, v uint64) mmdbValue {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append(mmdbControl(typ, len(b)), b...)
+}
+
+// mmdbMap encodes alternating keys and values.
+func mmdbMap(kv ...any) mmdbValue {
+	out := mmdbControl(7, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		out = append(out, mmdbString(kv[i].(string))...)
+		out = append(out, kv[i+1].(mmdbValue)...)
+	}
+	return out
+}
+
+func mmdbArray(vs ...mmdbValue) mmdbValue {
+	out := mmdbControl(11, len(vs))
+	for _, v := range vs {
+		out = append(out, v...)
+	}
+	return out
+}
+
+// countryRecord returns the data of a network in a country database.
+func countryRecord(country, continent string) mmdbValue {
+	return mmdbMap(
+		"continent", mmdbMap("code", mmdbString(continent)),
+		"country", mmdbMap("iso_code", mmdbString(country)),
+	)
+}
+
+// writeMMDB writes an IPv4 MaxMind DB with 24 bit records mapping the given
+// networks to their data.
+func writeMMDB(t *testing.T, path string, networks map[string]mmdbValue) {
+	t.Helper()
+	type node struct {
+		child [2]*node
+		data  mmdbValue
+	}
+	root := &node{}
+	for cidr, data := range networks {
+		p := netip.MustParsePrefix(cidr)
+		ip := p.Addr().As4()
+		n := root
+		for i := range p.Bits() {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.child[bit] == nil {
+				n.child[bit] = &node{}
+			}
+			n = n.child[bit]
+		}
+		n.data = data
+	}
+
+	// Number the inner nodes breadth first, then lay out the data section
+	var inner []*node
+	index := map[*node]int{}
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		index[n] = len(inner)
+		inner = append(inner, n)
+		for _, c := range n.child {
+			if c != nil && c.data == nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+	nodeCount := len(inner)
+	var data []byte
+	offsets := map[*node]int{}
+	for _, n := range inner {
+		for _, c := range n.child {
+			if c != nil && c.data != nil {
+				offsets[c] = len(data)
+				data = append(data, c.data...)
+			}
+		}
+	}
+
+	var out []byte
+	for _, n := range inner {
+		for _, c := range n.child {
+			record := nodeCount // empty
+			switch {
+			case c == nil:
+			case c.data != nil:
+				record = nodeCount + 16 + offsets[c]
+			default:
+				record = index[c]
+			}
+			out = append(out, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, data...)
+	out = append(out, "\xAB\xCD\xEFMaxMind.com"...)
+	out = append(out, mmdbMap(
+		"binary_format_major_version", mmdbUint(5, 2),
+		"binary_format_minor_version", mmdbUint(5, 0),
+		"build_epoch", mmdbUint(9, 1700000000),
+		"database_type", mmdbString("Test-Country"),
+		"description", mmdbMap("en", mmdbString("test")),
+		"ip_version", mmdbUint(5, 4),
+		"languages", mmdbArray(mmdbString("en")),
+		"node_count", mmdbUint(6, uint64(nodeCount)),
+		"record_size", mmdbUint(5, 24),
+	)...)
+
+	if err := os.WriteFile(path, out, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testCSV = `# office networks
+10.0.0.0/8, corp
+10.1.0.0/16,Corp-Berlin
+192.0.2.7,probe
+2001:db8::/32,corp-v6
+`
+
+func TestLocateCSV(t *testing.T) {
+	db, err := Open([]string{writeFile(t, "regions.csv", testCSV)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr    string
+		regions []string
+		bits    int
+	}{
+		{"10.2.3.4", []string{"corp"}, 8},
+		{"10.1.2.3", []string{"corp-berlin"}, 16},
+		{"::ffff:10.1.2.3", []string{"corp-berlin"}, 16},
+		{"192.0.2.7", []string{"probe"}, 32},
+		{"192.0.2.8", nil, 0},
+		{"2001:db8:1::1", []string{"corp-v6"}, 32},
+		// An IPv6 address must not match IPv4 networks through the shared table
+		{"::a01:203", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			loc := db.Locate(netip.MustParseAddr(tt.addr))
+			if !slices.Equal(loc.Regions, tt.regions) || loc.Bits != tt.bits {
+				t.Errorf("got %v/%d, want %v/%d", loc.Regions, loc.Bits, tt.regions, tt.bits)
+			}
+		})
+	}
+}
+
+func TestOpenCSVErrors(t *testing.T) {
+	for _, content := range []string{
+		"10.0.0.0/33,corp\n",
+		"not-a-network,corp\n",
+		"10.0.0.0/8,\n",
+		"10.0.0.0/8,corp,extra\n",
+	} {
+		if _, err := Open([]string{writeFile(t, "regions.csv", content)}); err == nil {
+			t.Errorf("%q: expected an error", content)
+		}
+	}
+	if _, err := Open([]string{" ", ""}); err == nil {
+		t.Error("expected an error without databases")
+	}
+	if _, err := Open([]string{filepath.Join(t.TempDir(), "missing.csv")}); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestLocateMMDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeMMDB(t, path, map[string]mmdbValue{
+		"192.0.2.0/24":    countryRecord("DE", "EU"),
+		"198.51.100.0/25": mmdbMap("continent", mmdbMap("code", mmdbString("NA")), "registered_country", mmdbMap("iso_code", mmdbString("US"))),
+	})
+	db, err := Open([]string{path, writeFile(t, "regions.csv", "192.0.2.128/25,dc-frankfurt\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr    string
+		regions []string
+		bits    int
+	}{
+		{"192.0.2.1", []string{"country:de", "continent:eu"}, 24},
+		// CSV regions come first, the prefix length is the most specific one
+		{"192.0.2.200", []string{"dc-frankfurt", "country:de", "continent:eu"}, 25},
+		// The registered country stands in for a missing country
+		{"198.51.100.1", []string{"country:us", "continent:na"}, 25},
+		// Unknown addresses still report the network they fall in
+		{"198.51.100.200", nil, 25},
+		// IPv6 clients cannot be looked up in an IPv4 database
+		{"2001:db8::1", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			loc := db.Locate(netip.MustParseAddr(tt.addr))
+			if !slices.Equal(loc.Regions, tt.regions) || loc.Bits != tt.bits {
+				t.Errorf("got %v/%d, want %v/%d", loc.Regions, loc.Bits, tt.regions, tt.bits)
+			}
+		})
+	}
+
+	if loc := db.Locate(netip.Addr{}); len(loc.Regions) != 0 || loc.Bits != 0 {
+		t.Errorf("invalid address located in %v/%d", loc.Regions, loc.Bits)
+	}
+}
+
+func TestLocationKey(t *testing.T) {
+	loc := Location{Regions: []string{"dc-frankfurt", "country:de", "continent:eu"}, Bits: 25}
+	key := loc.Key()
+	if key != "dc-frankfurt,country:de,continent:eu" {
+		t.Errorf("key %q", key)
+	}
+	if got := ParseKey(key); !slices.Equal(got.Regions, loc.Regions) {
+		t.Errorf("ParseKey(%q) = %v, want %v", key, got.Regions, loc.Regions)
+	}
+	if key := (Location{}).Key(); key != "-" {
+		t.Errorf("key %q of an unknown location, want -", key)
+	}
+	if got := ParseKey("-"); got.Regions != nil {
+		t.Errorf("ParseKey(-) = %v, want no regions", got.Regions)
+	}
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout applies to checks without a ?timeout= parameter.
+const defaultTimeout = 2 * time.Second
+
+// Check probes the endpoint behind a record with a TCP connect or an HTTP(S) GET.
+type Check struct {
+	// Scheme is "tcp", "http" or "https".
+	Scheme string
+	Addr   string
+	Path   string
+	// Host is sent as the HTTP Host header and TLS server name.
+	Host string
+	// Status is the HTTP status code expected from a healthy endpoint.
+	Status  int
+	Timeout time.Duration
+}
+
+// Parse parses a check of the form tcp://[host]:port or
+// http(s)://[host][:port]/path[?status=200&host=name&timeout=2s].
+// Without a host the check connects to target, the record's address or host name.
+func Parse(spec, target string) (*Check, error) {
+	uri, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check %q: %w", spec, err)
+	}
+
+	c := &Check{Scheme: uri.Scheme, Path: uri.Path, Status: http.StatusOK, Timeout: defaultTimeout}
+	port := uri.Port()
+	switch uri.Scheme {
+	case "tcp":
+		if port == "" {
+			return nil, fmt.Errorf("invalid health check %q: missing port", spec)
+		}
+	case "http":
+		port = cmp.Or(port, "80")
+	case "https":
+		port = cmp.Or(port, "443")
+	default:
+		return nil, fmt.Errorf("invalid health check %q: unsupported protocol %q", spec, uri.Scheme)
+	}
+
+	host := uri.Hostname()
+	if host == "" {
+		host = strings.TrimSuffix(target, ".")
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid health check %q: missing host", spec)
+	}
+	c.Addr = net.JoinHostPort(host, port)
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	params := uri.Query()
+	c.Host = params.Get("host")
+	if s := params.Get("status"); s != "" {
+		if c.Status, err = strconv.Atoi(s); err != nil || c.Status < 100 || c.Status > 599 {
+			return nil, fmt.Errorf("invalid health check %q: bad status %q", spec, s)
+		}
+	}
+	if t := params.Get("timeout"); t != "" {
+		if c.Timeout, err = time.ParseDuration(t); err != nil || c.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid health check %q: bad timeout %q", spec, t)
+		}
+	}
+	return c, nil
+}
+
+// Target identifies the checked endpoint. Records sharing a target share its state.
+func (c *Check) Target() string {
+	if c.Scheme == "tcp" {
+		return "tcp://" + c.Addr
+	}
+	params := url.Values{}
+	if c.Host != "" {
+		params.Set("host", c.Host)
+	}
+	if c.Status != http.StatusOK {
+		params.Set("status", strconv.Itoa(c.Status))
+	}
+	t := c.Scheme + "://" + c.Addr + c.Path
+	if len(params) > 0 {
+		t += "?" + params.Encode()
+	}
+	return t
+}
+
+// Run probes the endpoint once, returning nil when it is healthy.
+func (c *Check) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	if c.Scheme == "tcp" {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.Addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Scheme+"://"+c.Addr+c.Path, nil)
+	if err != nil {
+		return err
+	}
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			// Health checks probe liveness, backends are often addressed by IP
+			TLSClientConfig:   &tls.Config{ServerName: c.Host, InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		// Redirects are answers like any other status
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != c.Status {
+		return fmt.Errorf("status %d, want %d", resp.StatusCode, c.Status)
+	}
+	return nil
+}
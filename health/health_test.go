@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec, target string
+		addr, path   string
+		wantTarget   string
+	}{
+		{"tcp://:443", "192.0.2.1", "192.0.2.1:443", "/", "tcp://192.0.2.1:443"},
+		{"tcp://[2001:db8::1]:22", "", "[2001:db8::1]:22", "/", "tcp://[2001:db8::1]:22"},
+		{"http:///healthz", "backend.example.test.", "backend.example.test:80", "/healthz", "http://backend.example.test:80/healthz"},
+		{"https://:8443/?host=www.example.test&status=204&timeout=1s", "192.0.2.1", "192.0.2.1:8443", "/", "https://192.0.2.1:8443/?host=www.example.test&status=204"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := Parse(tt.spec, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Addr != tt.addr || c.Path != tt.path {
+				t.Errorf("got %s%s, want %s%s", c.Addr, c.Path, tt.addr, tt.path)
+			}
+			if got := c.Target(); got != tt.wantTarget {
+				t.Errorf("target %q, want %q", got, tt.wantTarget)
+			}
+		})
+	}
+
+	for _, spec := range []string{"tcp://192.0.2.1", "udp://192.0.2.1:53", "http:///?status=99", "http:///?timeout=0s", "http:///"} {
+		if _, err := Parse(spec, ""); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestRunTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	c := &Check{Scheme: "tcp", Addr: addr, Timeout: time.Second}
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("check of a listening port failed: %v", err)
+	}
+
+	l.Close()
+	if err := c.Run(context.Background()); err == nil {
+		t.Error("check of a closed port passed")
+	}
+}
+
+func TestRunHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host == "down.example.test":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/moved":
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"http://" + addr + "/", true},
+		{"http://" + addr + "/?host=down.example.test", false},
+		{"http://" + addr + "/moved", false},
+		{"http://" + addr + "/moved?status=302", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := Parse(tt.spec, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Run(context.Background()); (err == nil) != tt.ok {
+				t.Errorf("got %v, want passing %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRunHTTPS(t *testing.T) {
+	// Backends are checked without verifying their certificate
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	c, err := Parse(srv.URL+"/?host=www.example.test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	c, err := Parse(srv.URL+"/?timeout=100ms", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := c.Run(context.Background()); err == nil {
+		t.Error("check of a hanging endpoint passed")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("check took %s despite its 100ms timeout", d)
+	}
+}
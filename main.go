@@ -8,6 +8,16 @@ func main() {
 	root := cmd.RootCommand()
 	root.AddCommand(cmd.DaemonCommand())
 	root.AddCommand(cmd.AddRecordCommand())
+	root.AddCommand(cmd.AddZoneCommand())
+	root.AddCommand(cmd.GenerateKeysCommand())
+	root.AddCommand(cmd.ShowDSCommand())
+	root.AddCommand(cmd.AddTsigKeyCommand())
+	root.AddCommand(cmd.AddForwardRuleCommand())
+	root.AddCommand(cmd.AddViewCommand())
+	root.AddCommand(cmd.SetAnswerPolicyCommand())
+	root.AddCommand(cmd.SetQueryACLCommand())
+	root.AddCommand(cmd.AddRPZSourceCommand())
+	root.AddCommand(cmd.AddRPZOverrideCommand())
 	root.AddCommand(cmd.CacheRecordCommand())
 	root.AddCommand(cmd.TokenCommand())
 	root.AddCommand(cmd.ApiCommand())
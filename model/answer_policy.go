@@ -0,0 +1,47 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Answer policies.
+const (
+	// PolicyShuffle serves every record in a random order, heavier records first more often.
+	PolicyShuffle = "shuffle"
+	// PolicyWeighted serves a single record picked at random by weight.
+	PolicyWeighted = "weighted"
+	// PolicyTop serves the first Count records of a weighted random order.
+	PolicyTop = "top"
+)
+
+// AnswerPolicy selects which of a name's A and AAAA records are served, and in
+// which order. Names without a policy are answered in database order.
+type AnswerPolicy struct {
+	Domain string `json:"domain"`
+	Policy string `json:"policy"`
+	// Count is the number of records served by the top policy.
+	Count int `json:"count,omitempty"`
+}
+
+// SetDefaults normalises the policy and checks it.
+func (p *AnswerPolicy) SetDefaults() error {
+	p.Domain = strings.ToLower(strings.TrimSuffix(p.Domain, "."))
+	if _, ok := dns.IsDomainName(p.Domain); !ok || p.Domain == "" {
+		return fmt.Errorf("invalid domain %q", p.Domain)
+	}
+	p.Policy = strings.ToLower(p.Policy)
+	switch p.Policy {
+	case PolicyShuffle, PolicyWeighted:
+		p.Count = 0
+	case PolicyTop:
+		if p.Count < 1 {
+			return fmt.Errorf("top policy needs a count of at least 1")
+		}
+	default:
+		return fmt.Errorf("unsupported policy %q", p.Policy)
+	}
+	return nil
+}
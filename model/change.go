@@ -0,0 +1,16 @@
+package model
+
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "del"
+)
+
+// Change is a single record addition or removal recorded in a zone's journal.
+// Every change applied in one transaction moves the zone from FromSerial to ToSerial.
+type Change struct {
+	Zone       string `json:"zone"`
+	FromSerial uint32 `json:"from_serial"`
+	ToSerial   uint32 `json:"to_serial"`
+	Op         string `json:"op"`
+	Record     Record `json:"record"`
+}
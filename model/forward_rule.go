@@ -0,0 +1,58 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ForwardRule sends queries for a domain suffix to dedicated upstream resolvers
+// instead of answering them from our records.
+type ForwardRule struct {
+	Suffix string `json:"suffix"`
+	// Upstreams are host[:port] addresses, optionally with their own protocol scheme.
+	Upstreams []string `json:"upstreams"`
+	// Protocol is used for upstreams without a scheme: udp, tcp or tls.
+	Protocol string `json:"protocol"`
+	// Cache stores the upstream answers in Redis.
+	Cache bool `json:"cache"`
+}
+
+// SetDefaults normalises the rule and checks the suffix and protocol.
+func (r *ForwardRule) SetDefaults() error {
+	r.Suffix = strings.ToLower(strings.TrimSuffix(r.Suffix, "."))
+	if _, ok := dns.IsDomainName(r.Suffix); !ok || r.Suffix == "" {
+		return fmt.Errorf("invalid suffix %q", r.Suffix)
+	}
+	if r.Protocol == "" {
+		r.Protocol = "udp"
+	}
+	switch r.Protocol {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("unsupported protocol %q", r.Protocol)
+	}
+	if len(r.Upstreams) == 0 {
+		return fmt.Errorf("rule for %s has no upstreams", r.Suffix)
+	}
+	return nil
+}
+
+// Specs returns the upstreams with the rule protocol applied to those without a scheme.
+func (r ForwardRule) Specs() []string {
+	out := make([]string, 0, len(r.Upstreams))
+	for _, u := range r.Upstreams {
+		if !strings.Contains(u, "://") {
+			u = r.Protocol + "://" + u
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+// Matches reports whether domain equals the suffix or is below it.
+func (r ForwardRule) Matches(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return domain == r.Suffix || strings.HasSuffix(domain, "."+r.Suffix)
+}
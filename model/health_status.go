@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// HealthStatus is the last known state of a health-checked endpoint.
+type HealthStatus struct {
+	Target    string    `json:"target"`
+	Healthy   bool      `json:"healthy"`
+	LastError string    `json:"last_error,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSEC key flags (RFC 4034): zone signing keys sign the zone data, key
+// signing keys (SEP bit set) sign the DNSKEY set and are referenced by the parent's DS.
+const (
+	FlagZSK uint16 = 256
+	FlagKSK uint16 = 257
+)
+
+type Key struct {
+	ID         int    `json:"id"`
+	Zone       string `json:"zone"`
+	Flags      uint16 `json:"flags"`
+	Algorithm  uint8  `json:"algorithm"`
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"-"`
+}
+
+// IsKSK reports whether the key has the secure entry point flag set.
+func (k Key) IsKSK() bool {
+	return k.Flags&dns.SEP != 0
+}
+
+// DNSKEY returns the public key record published at the zone apex.
+func (k Key) DNSKEY(ttl int) *dns.DNSKEY {
+	return &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(k.Zone),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(ttl),
+		},
+		Flags:     k.Flags,
+		Protocol:  3,
+		Algorithm: k.Algorithm,
+		PublicKey: k.PublicKey,
+	}
+}
+
+// Signer parses the stored private key.
+func (k Key) Signer() (crypto.Signer, error) {
+	priv, err := k.DNSKEY(0).NewPrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %d of %s: %w", k.ID, k.Zone, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key %d of %s cannot sign", k.ID, k.Zone)
+	}
+	return signer, nil
+}
+
+// GenerateKey creates a new key pair for zone with the given flags and algorithm name.
+func GenerateKey(zone string, flags uint16, algorithm string) (Key, error) {
+	alg, ok := dns.StringToAlgorithm[strings.ToUpper(algorithm)]
+	if !ok {
+		return Key{}, fmt.Errorf("unknown DNSSEC algorithm %q", algorithm)
+	}
+	var bits int
+	switch alg {
+	case dns.ECDSAP256SHA256, dns.ED25519:
+		bits = 256
+	case dns.ECDSAP384SHA384:
+		bits = 384
+	case dns.RSASHA256, dns.RSASHA512:
+		bits = 2048
+	default:
+		return Key{}, fmt.Errorf("unsupported DNSSEC algorithm %q", algorithm)
+	}
+
+	k := Key{Zone: strings.ToLower(strings.TrimSuffix(zone, ".")), Flags: flags, Algorithm: alg}
+	dnskey := k.DNSKEY(0)
+	priv, err := dnskey.Generate(bits)
+	if err != nil {
+		return Key{}, err
+	}
+	k.PublicKey = dnskey.PublicKey
+	k.PrivateKey = dnskey.PrivateKeyString(priv)
+	return k, nil
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/extremtechniker/godns/util"
+	"github.com/miekg/dns"
+)
+
+// Transports queries arrive over, which query ACLs can be scoped to.
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+	// TransportDoT is DNS-over-TLS (RFC 7858).
+	TransportDoT = "dot"
+	// TransportDoH is DNS-over-HTTPS (RFC 8484), on DOH_LISTEN or the HTTP API.
+	TransportDoH = "doh"
+	// TransportDoQ is DNS-over-QUIC (RFC 9250).
+	TransportDoQ = "doq"
+)
+
+// QueryACL restricts which clients may query a zone, or the whole server when
+// Zone is empty. Denied networks take precedence over allowed ones.
+type QueryACL struct {
+	Zone string `json:"zone"`
+	// Allow lists the only client CIDRs permitted, when not empty.
+	Allow []string `json:"allow"`
+	// Deny lists client CIDRs that are refused.
+	Deny []string `json:"deny"`
+	// Transports limits the ACL to queries arriving over these transports;
+	// it applies to all of them when empty.
+	Transports []string `json:"transports"`
+	// Listen limits the ACL to queries received on these local addresses;
+	// it applies on all of them when empty.
+	Listen []string `json:"listen"`
+}
+
+// SetDefaults normalises the ACL and checks its networks.
+func (a *QueryACL) SetDefaults() error {
+	a.Zone = strings.ToLower(strings.TrimSuffix(a.Zone, "."))
+	if _, ok := dns.IsDomainName(a.Zone); !ok && a.Zone != "" {
+		return fmt.Errorf("invalid zone %q", a.Zone)
+	}
+	for _, s := range append(append([]string{}, a.Allow...), a.Deny...) {
+		if _, err := util.ParsePrefix(s); err != nil {
+			return fmt.Errorf("invalid network %q: %w", s, err)
+		}
+	}
+	for _, s := range a.Listen {
+		if _, err := util.ParsePrefix(s); err != nil {
+			return fmt.Errorf("invalid listener address %q: %w", s, err)
+		}
+	}
+	for i, t := range a.Transports {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case TransportUDP, TransportTCP, TransportDoT, TransportDoH, TransportDoQ:
+		default:
+			return fmt.Errorf("unsupported transport %q", t)
+		}
+		a.Transports[i] = t
+	}
+	if a.Allow == nil {
+		a.Allow = []string{}
+	}
+	if a.Deny == nil {
+		a.Deny = []string{}
+	}
+	if a.Transports == nil {
+		a.Transports = []string{}
+	}
+	if a.Listen == nil {
+		a.Listen = []string{}
+	}
+	return nil
+}
+
+// Empty reports whether the ACL permits every client.
+func (a QueryACL) Empty() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0
+}
+
+// AppliesTo reports whether the ACL covers queries arriving over transport
+// on the local address.
+func (a QueryACL) AppliesTo(transport string, local netip.Addr) bool {
+	if len(a.Transports) > 0 && !slices.Contains(a.Transports, transport) {
+		return false
+	}
+	return len(a.Listen) == 0 || util.PrefixesContain(a.Listen, local)
+}
+
+// Permits reports whether a client at addr may query.
+func (a QueryACL) Permits(addr netip.Addr) bool {
+	if util.PrefixesContain(a.Deny, addr) {
+		return false
+	}
+	return len(a.Allow) == 0 || util.PrefixesContain(a.Allow, addr)
+}
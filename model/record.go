@@ -1,8 +1,267 @@
 package model
 
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/extremtechniker/godns/health"
+	"github.com/miekg/dns"
+)
+
 type Record struct {
 	Domain string `json:"domain"`
 	QType  string `json:"qtype"`
 	TTL    int    `json:"ttl"`
 	Value  string `json:"value"`
+	// View limits the record to clients of a split-horizon view; empty serves everyone.
+	View string `json:"view,omitempty"`
+	// Region limits the record to clients located in a GeoDNS region (e.g.
+	// "country:de", "continent:eu" or a region of the CSV database); clients
+	// outside every region of the record set get the records without one.
+	Region string `json:"region,omitempty"`
+	// Weight is the relative share of answers the record gets under the name's
+	// answer policy; records added without one weigh 1.
+	Weight int `json:"weight,omitempty"`
+	// HealthCheck is a health.Parse check; the record is only served while it passes.
+	HealthCheck string `json:"health_check,omitempty"`
+	// Backup records are only served once every other record of the set is unhealthy.
+	Backup bool `json:"backup,omitempty"`
+}
+
+// Check returns the health check of the record, nil when it has none. Checks
+// without a host probe the address or host name of A, AAAA, CNAME and ALIAS records.
+func (r Record) Check() (*health.Check, error) {
+	if r.HealthCheck == "" {
+		return nil, nil
+	}
+	target := ""
+	switch strings.ToUpper(r.QType) {
+	case "A", "AAAA", "CNAME", "ALIAS":
+		target = r.Value
+	}
+	return health.Parse(r.HealthCheck, target)
+}
+
+// Validate checks that the record type is supported and its value can be parsed.
+func (r Record) Validate() error {
+	if r.Weight < 0 {
+		return fmt.Errorf("invalid weight %d", r.Weight)
+	}
+	if _, err := r.Check(); err != nil {
+		return err
+	}
+	// ALIAS only exists in our data and is flattened into A/AAAA answers at query time
+	if strings.EqualFold(r.QType, "ALIAS") {
+		_, err := parseName(r.Value)
+		return err
+	}
+	_, err := r.RR()
+	return err
+}
+
+// RR converts the record into a wire-ready resource record.
+// Structured values use zone file presentation order:
+//
+//	MX    <priority> <host>
+//	SRV   <priority> <weight> <port> <target>
+//	CAA   <flags> <tag> <value>
+//	TXT   <text> or "<string>" "<string>"...
+func (r Record) RR() (dns.RR, error) {
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{
+			Name:   dns.Fqdn(r.Domain),
+			Rrtype: rrtype,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(r.TTL),
+		}
+	}
+	fields := strings.Fields(r.Value)
+
+	switch strings.ToUpper(r.QType) {
+	case "A":
+		ip := net.ParseIP(r.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", r.Value)
+		}
+		return &dns.A{Hdr: hdr(dns.TypeA), A: ip}, nil
+
+	case "AAAA":
+		ip := net.ParseIP(r.Value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address %q", r.Value)
+		}
+		return &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: ip}, nil
+
+	case "CNAME":
+		target, err := parseName(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: target}, nil
+
+	case "NS":
+		ns, err := parseName(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.NS{Hdr: hdr(dns.TypeNS), Ns: ns}, nil
+
+	case "PTR":
+		ptr, err := parseName(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.PTR{Hdr: hdr(dns.TypePTR), Ptr: ptr}, nil
+
+	case "TXT":
+		txt, err := parseTXT(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: txt}, nil
+
+	case "MX":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("MX value must be \"<priority> <host>\", got %q", r.Value)
+		}
+		pref, err := parseUint16("MX priority", fields[0])
+		if err != nil {
+			return nil, err
+		}
+		host, err := parseName(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return &dns.MX{Hdr: hdr(dns.TypeMX), Preference: pref, Mx: host}, nil
+
+	case "SRV":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("SRV value must be \"<priority> <weight> <port> <target>\", got %q", r.Value)
+		}
+		prio, err := parseUint16("SRV priority", fields[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := parseUint16("SRV weight", fields[1])
+		if err != nil {
+			return nil, err
+		}
+		port, err := parseUint16("SRV port", fields[2])
+		if err != nil {
+			return nil, err
+		}
+		target, err := parseName(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		return &dns.SRV{Hdr: hdr(dns.TypeSRV), Priority: prio, Weight: weight, Port: port, Target: target}, nil
+
+	case "CAA":
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("CAA value must be \"<flags> <tag> <value>\", got %q", r.Value)
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAA flags %q", fields[0])
+		}
+		tag := strings.ToLower(fields[1])
+		switch tag {
+		case "issue", "issuewild", "iodef":
+		default:
+			return nil, fmt.Errorf("unsupported CAA tag %q", fields[1])
+		}
+		// The value may contain spaces and is usually quoted in zone files
+		value := strings.SplitN(r.Value, fields[1], 2)[1]
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		return &dns.CAA{Hdr: hdr(dns.TypeCAA), Flag: uint8(flags), Tag: tag, Value: value}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported record type %q", r.QType)
+}
+
+func parseName(s string) (string, error) {
+	if _, ok := dns.IsDomainName(s); !ok || s == "" {
+		return "", fmt.Errorf("invalid domain name %q", s)
+	}
+	return dns.Fqdn(s), nil
+}
+
+// maxTXTString is the length limit of a single TXT character-string.
+const maxTXTString = 255
+
+// parseTXT returns the character-strings of a TXT value. Values starting with
+// a quote are quoted presentation format, keeping the boundaries of several
+// strings (DKIM keys, long SPF records); anything else is a single text split
+// into strings of at most 255 bytes.
+func parseTXT(s string) ([]string, error) {
+	if strings.HasPrefix(s, `"`) {
+		rr, err := dns.NewRR(". TXT " + s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TXT value %q: %w", s, err)
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("invalid TXT value %q", s)
+		}
+		return rr.(*dns.TXT).Txt, nil
+	}
+
+	var txt []string
+	for len(s) > maxTXTString {
+		txt = append(txt, s[:maxTXTString])
+		s = s[maxTXTString:]
+	}
+	return append(txt, s), nil
+}
+
+// formatTXT returns the value of a TXT record, the inverse of parseTXT.
+func formatTXT(t *dns.TXT) string {
+	if len(t.Txt) == 1 && !strings.HasPrefix(t.Txt[0], `"`) {
+		return t.Txt[0]
+	}
+	return strings.TrimPrefix(t.String(), t.Hdr.String())
+}
+
+func parseUint16(field, s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", field, s)
+	}
+	return uint16(v), nil
+}
+
+// RecordFromRR converts a resource record received from another server, e.g. in a
+// zone transfer. It reports false for types we cannot store.
+func RecordFromRR(rr dns.RR) (Record, bool) {
+	h := rr.Header()
+	r := Record{
+		Domain: strings.ToLower(strings.TrimSuffix(h.Name, ".")),
+		QType:  dns.TypeToString[h.Rrtype],
+		TTL:    int(h.Ttl),
+	}
+
+	switch v := rr.(type) {
+	case *dns.A:
+		r.Value = v.A.String()
+	case *dns.AAAA:
+		r.Value = v.AAAA.String()
+	case *dns.CNAME:
+		r.Value = strings.TrimSuffix(v.Target, ".")
+	case *dns.NS:
+		r.Value = strings.TrimSuffix(v.Ns, ".")
+	case *dns.PTR:
+		r.Value = strings.TrimSuffix(v.Ptr, ".")
+	case *dns.TXT:
+		r.Value = formatTXT(v)
+	case *dns.MX:
+		r.Value = fmt.Sprintf("%d %s", v.Preference, strings.TrimSuffix(v.Mx, "."))
+	case *dns.SRV:
+		r.Value = fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, strings.TrimSuffix(v.Target, "."))
+	case *dns.CAA:
+		r.Value = fmt.Sprintf("%d %s %s", v.Flag, v.Tag, v.Value)
+	default:
+		return Record{}, false
+	}
+	return r, true
 }
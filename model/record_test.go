@@ -0,0 +1,161 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordRR(t *testing.T) {
+	tests := []struct {
+		qtype, value string
+		want         string
+	}{
+		{"A", "192.0.2.1", "www.example.com.\t300\tIN\tA\t192.0.2.1"},
+		{"a", "192.0.2.1", "www.example.com.\t300\tIN\tA\t192.0.2.1"},
+		{"AAAA", "2001:db8::1", "www.example.com.\t300\tIN\tAAAA\t2001:db8::1"},
+		{"CNAME", "web.example.net", "www.example.com.\t300\tIN\tCNAME\tweb.example.net."},
+		{"NS", "ns1.example.com.", "www.example.com.\t300\tIN\tNS\tns1.example.com."},
+		{"PTR", "host.example.com", "www.example.com.\t300\tIN\tPTR\thost.example.com."},
+		{"TXT", "hello world", "www.example.com.\t300\tIN\tTXT\t\"hello world\""},
+		{"TXT", `"v=spf1" "~all"`, "www.example.com.\t300\tIN\tTXT\t\"v=spf1\" \"~all\""},
+		{"MX", "10 mail.example.com", "www.example.com.\t300\tIN\tMX\t10 mail.example.com."},
+		{"SRV", "10 60 5060 sip.example.com", "www.example.com.\t300\tIN\tSRV\t10 60 5060 sip.example.com."},
+		{"CAA", "0 issue letsencrypt.org", "www.example.com.\t300\tIN\tCAA\t0 issue \"letsencrypt.org\""},
+		{"CAA", `128 IODEF "mailto:security@example.com"`, "www.example.com.\t300\tIN\tCAA\t128 iodef \"mailto:security@example.com\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qtype+" "+tt.value, func(t *testing.T) {
+			rec := Record{Domain: "www.example.com", QType: tt.qtype, TTL: 300, Value: tt.value}
+			rr, err := rec.RR()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rr.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if err := rec.Validate(); err != nil {
+				t.Errorf("valid record rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordRRInvalid(t *testing.T) {
+	tests := []struct{ qtype, value string }{
+		{"A", "2001:db8::1"},
+		{"A", "not-an-address"},
+		{"AAAA", "192.0.2.1"},
+		{"CNAME", ""},
+		{"NS", "bad..name"},
+		{"PTR", ""},
+		{"TXT", `"unterminated`},
+		{"MX", "mail.example.com"},
+		{"MX", "65536 mail.example.com"},
+		{"SRV", "10 60 sip.example.com"},
+		{"SRV", "10 60 port sip.example.com"},
+		{"CAA", "0 issue"},
+		{"CAA", "256 issue ca.example"},
+		{"CAA", "0 contactemail ca.example"},
+		{"SOA", "ns.example.com hostmaster.example.com 1 7200 900 1209600 60"},
+		{"ALIAS", "lb..example.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qtype+" "+tt.value, func(t *testing.T) {
+			if err := (Record{Domain: "www.example.com", QType: tt.qtype, TTL: 300, Value: tt.value}).Validate(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// ALIAS records are flattened at query time and have no resource record of their own
+	alias := Record{Domain: "example.com", QType: "ALIAS", TTL: 300, Value: "lb.example.net"}
+	if err := alias.Validate(); err != nil {
+		t.Errorf("ALIAS rejected: %v", err)
+	}
+	if _, err := alias.RR(); err == nil {
+		t.Error("ALIAS converted into a resource record")
+	}
+
+	for _, rec := range []Record{
+		{Domain: "example.com", QType: "A", Value: "192.0.2.1", Weight: -1},
+		{Domain: "example.com", QType: "A", Value: "192.0.2.1", HealthCheck: "udp://:53"},
+	} {
+		if err := rec.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", rec)
+		}
+	}
+	rec := Record{Domain: "example.com", QType: "A", Value: "192.0.2.1", Weight: 3, HealthCheck: "tcp://:443"}
+	if err := rec.Validate(); err != nil {
+		t.Errorf("valid record rejected: %v", err)
+	}
+}
+
+func TestTXTRoundTrip(t *testing.T) {
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A", 12)
+	tests := []struct {
+		name string
+		txt  []string
+	}{
+		{"single string", []string{"v=spf1 include:_spf.example.com ~all"}},
+		{"split DKIM key", []string{dkim[:255], dkim[255:]}},
+		// miekg/dns keeps character-strings in presentation format
+		{"quotes and escapes", []string{`\"quoted\"`, `back\\slash`, `\009tab`, "semi;colon"}},
+		{"empty string", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := &dns.TXT{Hdr: dns.RR_Header{Name: "Mail._DomainKey.Example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300}, Txt: tt.txt}
+			rec, ok := RecordFromRR(rr)
+			if !ok {
+				t.Fatal("TXT not converted")
+			}
+			if rec.Domain != "mail._domainkey.example.com" {
+				t.Errorf("domain %q, want it lowercased", rec.Domain)
+			}
+
+			out, err := rec.RR()
+			if err != nil {
+				t.Fatalf("RR(%q): %v", rec.Value, err)
+			}
+			if got := out.(*dns.TXT).Txt; !slices.Equal(got, tt.txt) {
+				t.Errorf("got %q, want %q", got, tt.txt)
+			}
+			msg := new(dns.Msg)
+			msg.Answer = []dns.RR{out}
+			if _, err := msg.Pack(); err != nil {
+				t.Errorf("failed to pack: %v", err)
+			}
+		})
+	}
+}
+
+func TestTXTLongValue(t *testing.T) {
+	value := strings.Repeat("a", 600)
+	rr, err := Record{Domain: "example.com", QType: "TXT", TTL: 300, Value: value}.RR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txt := rr.(*dns.TXT).Txt
+	if len(txt) != 3 || len(txt[0]) != 255 || len(txt[1]) != 255 || strings.Join(txt, "") != value {
+		t.Errorf("got strings of %d bytes, want the value split into 255 byte strings", lengths(txt))
+	}
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{rr}
+	if _, err := msg.Pack(); err != nil {
+		t.Errorf("failed to pack: %v", err)
+	}
+}
+
+func lengths(ss []string) []int {
+	out := make([]int, len(ss))
+	for i, s := range ss {
+		out[i] = len(s)
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/extremtechniker/godns/rpz"
+	"github.com/miekg/dns"
+)
+
+// Formats of response policy sources.
+const (
+	// RPZFormatHosts is a hosts file ("0.0.0.0 ads.example.com").
+	RPZFormatHosts = "hosts"
+	// RPZFormatDomains is a list of domains, one per line, covering the names below them too.
+	RPZFormatDomains = "domains"
+	// RPZFormatZone is a response policy zone named after the source.
+	RPZFormatZone = "rpz"
+)
+
+// RPZSource is a blocklist or response policy zone applied to queries before
+// they are resolved. Sources are read from a local file, RPZ zones can also be
+// transferred from a primary via AXFR.
+type RPZSource struct {
+	// Name identifies the source; for RPZ zones it is the zone name.
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// File is the local file the source is read from.
+	File string `json:"file,omitempty"`
+	// Primary is the server (host[:port]) an RPZ zone is transferred from.
+	Primary string `json:"primary,omitempty"`
+	// Key names the TSIG key transfers from Primary are signed with.
+	Key string `json:"key,omitempty"`
+	// Policy applies to every name of the source. It defaults to nxdomain for
+	// lists, while RPZ zones default to the actions given in the zone.
+	Policy string `json:"policy,omitempty"`
+	// Sinkhole lists the addresses served by the redirect policy.
+	Sinkhole []string `json:"sinkhole,omitempty"`
+	// Refresh is how often the source is reloaded, in seconds.
+	Refresh int `json:"refresh"`
+	// Priority orders sources; the first one with a rule for a name decides.
+	Priority int `json:"priority"`
+}
+
+// SetDefaults normalises the source and checks it.
+func (s *RPZSource) SetDefaults() error {
+	s.Name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s.Name), "."))
+	if s.Name == "" {
+		return fmt.Errorf("source name is required")
+	}
+	s.Format = strings.ToLower(s.Format)
+	switch s.Format {
+	case RPZFormatHosts, RPZFormatDomains:
+		if s.Primary != "" {
+			return fmt.Errorf("only rpz sources can be transferred from a primary")
+		}
+		if s.Policy == "" {
+			s.Policy = string(rpz.NXDomain)
+		}
+	case RPZFormatZone:
+		if _, ok := dns.IsDomainName(s.Name); !ok {
+			return fmt.Errorf("invalid zone %q", s.Name)
+		}
+	default:
+		return fmt.Errorf("unsupported format %q", s.Format)
+	}
+	if (s.File == "") == (s.Primary == "") {
+		return fmt.Errorf("source %s needs either a file or a primary", s.Name)
+	}
+	s.Key = strings.ToLower(strings.TrimSuffix(s.Key, "."))
+	if s.Refresh <= 0 {
+		s.Refresh = 3600
+	}
+	if s.Sinkhole == nil {
+		s.Sinkhole = []string{}
+	}
+	if s.Policy == "" {
+		return nil
+	}
+	// Hosts files may redirect to the addresses they list
+	rule, err := policyRule(s.Policy, s.Sinkhole, s.Format != RPZFormatHosts)
+	s.Policy = string(rule.Policy)
+	return err
+}
+
+// Rule returns the rule applied to every name of the source, when it has a policy.
+func (s RPZSource) Rule() (rpz.Rule, error) {
+	return policyRule(s.Policy, s.Sinkhole, false)
+}
+
+// RPZOverride is a local policy for a name (or *.name), taking precedence over
+// every source, e.g. passthru for a name a blocklist catches by mistake.
+type RPZOverride struct {
+	Name     string   `json:"name"`
+	Policy   string   `json:"policy"`
+	Sinkhole []string `json:"sinkhole,omitempty"`
+}
+
+// SetDefaults normalises the override and checks it.
+func (o *RPZOverride) SetDefaults() error {
+	o.Name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o.Name), "."))
+	if _, ok := dns.IsDomainName(o.Name); !ok || o.Name == "" {
+		return fmt.Errorf("invalid name %q", o.Name)
+	}
+	if o.Sinkhole == nil {
+		o.Sinkhole = []string{}
+	}
+	rule, err := policyRule(o.Policy, o.Sinkhole, true)
+	o.Policy = string(rule.Policy)
+	return err
+}
+
+// Rule returns the rule of the override.
+func (o RPZOverride) Rule() (rpz.Rule, error) {
+	return policyRule(o.Policy, o.Sinkhole, true)
+}
+
+// policyRule builds the rule of a policy, checking the sinkhole addresses of
+// redirects and requiring some when needSinkhole is set.
+func policyRule(policy string, sinkhole []string, needSinkhole bool) (rpz.Rule, error) {
+	p, err := rpz.ParsePolicy(policy)
+	if err != nil {
+		return rpz.Rule{}, err
+	}
+	if p != rpz.Redirect {
+		return rpz.Rule{Policy: p}, nil
+	}
+	if needSinkhole && len(sinkhole) == 0 {
+		return rpz.Rule{}, fmt.Errorf("redirect policy needs a sinkhole address")
+	}
+	data, err := rpz.Sinkhole(sinkhole)
+	return rpz.Rule{Policy: p, Data: data}, err
+}
+
+// RPZHits counts the queries a policy of a source (or "override") was applied to.
+type RPZHits struct {
+	Source string `json:"source"`
+	Policy string `json:"policy"`
+	Hits   int64  `json:"hits"`
+}
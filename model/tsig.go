@@ -0,0 +1,42 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+type TsigKey struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+// SetDefaults normalises the key and generates a random secret when none is given.
+func (k *TsigKey) SetDefaults() error {
+	k.Name = strings.ToLower(strings.TrimSuffix(k.Name, "."))
+	if k.Algorithm == "" {
+		k.Algorithm = dns.HmacSHA256
+	}
+	k.Algorithm = dns.CanonicalName(k.Algorithm)
+	switch k.Algorithm {
+	case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+	default:
+		return fmt.Errorf("unsupported TSIG algorithm %q", k.Algorithm)
+	}
+
+	if k.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		k.Secret = base64.StdEncoding.EncodeToString(b)
+	}
+	if _, err := base64.StdEncoding.DecodeString(k.Secret); err != nil {
+		return fmt.Errorf("TSIG secret must be base64: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/extremtechniker/godns/util"
+)
+
+// View selects the records served to a group of clients (split horizon).
+// A client belongs to the first view, by priority, whose criteria it all meets.
+type View struct {
+	Name string `json:"name"`
+	// Networks lists the client CIDRs of the view.
+	Networks []string `json:"networks"`
+	// TsigKey requires queries to be signed with the named key.
+	TsigKey string `json:"tsig_key,omitempty"`
+	// Listen lists the local addresses the query must arrive on.
+	Listen []string `json:"listen,omitempty"`
+	// Priority orders views; lower values are matched first.
+	Priority int `json:"priority"`
+}
+
+// SetDefaults normalises the view and checks its addresses.
+func (v *View) SetDefaults() error {
+	v.Name = strings.ToLower(strings.TrimSpace(v.Name))
+	if v.Name == "" {
+		return fmt.Errorf("view name is required")
+	}
+	v.TsigKey = strings.ToLower(strings.TrimSuffix(v.TsigKey, "."))
+	for _, s := range append(append([]string{}, v.Networks...), v.Listen...) {
+		if _, err := util.ParsePrefix(s); err != nil {
+			return fmt.Errorf("invalid address %q: %w", s, err)
+		}
+	}
+	if v.Networks == nil {
+		v.Networks = []string{}
+	}
+	if v.Listen == nil {
+		v.Listen = []string{}
+	}
+	return nil
+}
+
+// Matches reports whether a query from remote, received on local and signed
+// with key ("" when unsigned), belongs to the view.
+func (v View) Matches(remote, local netip.Addr, key string) bool {
+	if len(v.Networks) > 0 && !util.PrefixesContain(v.Networks, remote) {
+		return false
+	}
+	if v.TsigKey != "" && v.TsigKey != key {
+		return false
+	}
+	if len(v.Listen) > 0 && !util.PrefixesContain(v.Listen, local) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type Zone struct {
+	Name    string `json:"name"`
+	Ns      string `json:"ns"`
+	Mbox    string `json:"mbox"`
+	Serial  uint32 `json:"serial"`
+	Refresh int    `json:"refresh"`
+	Retry   int    `json:"retry"`
+	Expire  int    `json:"expire"`
+	Minimum int    `json:"minimum"`
+	TTL     int    `json:"ttl"`
+	// TransferACL lists the client networks allowed to AXFR/IXFR the zone.
+	TransferACL []string `json:"transfer_acl"`
+	// TransferKey names the TSIG key secondaries must sign transfer requests with.
+	TransferKey string `json:"transfer_key"`
+	// AlsoNotify lists the secondaries (host[:port]) sent a NOTIFY when the zone changes.
+	AlsoNotify []string `json:"also_notify"`
+	// Primary is the address of the external primary for secondary zones.
+	Primary string `json:"primary,omitempty"`
+	// PrimaryKey names the TSIG key used for transfers from the primary.
+	PrimaryKey string `json:"primary_key,omitempty"`
+	// UpdateKey names the TSIG key clients must sign dynamic updates (RFC 2136) with.
+	UpdateKey string `json:"update_key,omitempty"`
+	// Refreshed is when a secondary zone was last confirmed current with its primary.
+	Refreshed *time.Time `json:"refreshed,omitempty"`
+}
+
+// SetDefaults fills in SOA values that were not provided when the zone was created.
+func (z *Zone) SetDefaults() {
+	z.Name = strings.ToLower(strings.TrimSuffix(z.Name, "."))
+	if z.Ns == "" {
+		z.Ns = "ns1." + z.Name
+	}
+	if z.Mbox == "" {
+		z.Mbox = "hostmaster." + z.Name
+	}
+	// Secondary zones take their serial from the primary on the first transfer
+	if z.Serial == 0 && !z.IsSecondary() {
+		z.Serial = InitialSerial(time.Now())
+	}
+	if z.Refresh == 0 {
+		z.Refresh = 3600
+	}
+	if z.Retry == 0 {
+		z.Retry = 600
+	}
+	if z.Expire == 0 {
+		z.Expire = 604800
+	}
+	if z.Minimum == 0 {
+		z.Minimum = 300
+	}
+	if z.TTL == 0 {
+		z.TTL = 3600
+	}
+}
+
+// IsSecondary reports whether the zone is transferred from an external primary.
+func (z Zone) IsSecondary() bool {
+	return z.Primary != ""
+}
+
+// Expired reports whether a secondary zone has not been confirmed current with
+// its primary within the SOA expire time and must no longer be served.
+func (z Zone) Expired(now time.Time) bool {
+	if !z.IsSecondary() {
+		return false
+	}
+	return z.Refreshed == nil || now.Sub(*z.Refreshed) > time.Duration(z.Expire)*time.Second
+}
+
+// SerialNewer reports whether serial a is newer than b using RFC 1982 serial number arithmetic.
+func SerialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// InitialSerial returns a date based serial in the common YYYYMMDDnn format.
+func InitialSerial(t time.Time) uint32 {
+	y, m, d := t.UTC().Date()
+	return uint32(y*1000000 + int(m)*10000 + d*100)
+}
+
+// NextSerial returns the serial following current, jumping to today's date
+// based serial when that is ahead.
+func NextSerial(current uint32, t time.Time) uint32 {
+	if s := InitialSerial(t); s > current {
+		return s
+	}
+	return current + 1
+}
+
+// SOA builds the start of authority record served at the zone apex.
+func (z Zone) SOA() *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(z.Name),
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(z.TTL),
+		},
+		Ns:      dns.Fqdn(z.Ns),
+		Mbox:    dns.Fqdn(z.Mbox),
+		Serial:  z.Serial,
+		Refresh: uint32(z.Refresh),
+		Retry:   uint32(z.Retry),
+		Expire:  uint32(z.Expire),
+		Minttl:  uint32(z.Minimum),
+	}
+}
+
+// Contains reports whether domain is the zone apex or one of its children.
+func (z Zone) Contains(domain string) bool {
+	return dns.IsSubDomain(dns.Fqdn(z.Name), dns.Fqdn(domain))
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/extremtechniker/godns/db"
+	"github.com/extremtechniker/godns/logger"
+	"github.com/extremtechniker/godns/model"
+	"github.com/miekg/dns"
+)
+
+const (
+	// attempts is how many times a NOTIFY is sent before giving up on a secondary.
+	attempts = 5
+	// initialBackoff doubles after every unanswered attempt.
+	initialBackoff = time.Second
+)
+
+// Zone sends NOTIFY (RFC 1996) for the zone owning domain to all of its
+// configured secondaries and waits until each acknowledged or ran out of retries.
+// Names outside any zone, and zones without secondaries, are ignored.
+func Zone(ctx context.Context, domain string) error {
+	zone, err := db.FindZone(ctx, domain)
+	if err != nil || zone == nil || len(zone.AlsoNotify) == 0 {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(zone.AlsoNotify))
+	for i, target := range zone.AlsoNotify {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = send(ctx, zone, target)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// send notifies a single secondary, retrying with exponential backoff.
+func send(ctx context.Context, zone *model.Zone, target string) error {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "53")
+	}
+
+	c := &dns.Client{Timeout: 2 * time.Second}
+	var keyName, alg string
+	if zone.TransferKey != "" {
+		key, err := db.GetTsigKey(ctx, zone.TransferKey)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			keyName, alg = dns.Fqdn(key.Name), key.Algorithm
+			c.TsigSecret = map[string]string{keyName: key.Secret}
+		}
+	}
+
+	backoff := initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		m := new(dns.Msg)
+		m.SetNotify(dns.Fqdn(zone.Name))
+		m.Authoritative = true
+		m.Answer = []dns.RR{zone.SOA()}
+		if keyName != "" {
+			m.SetTsig(keyName, alg, 300, time.Now().Unix())
+		}
+
+		resp, _, err := c.ExchangeContext(ctx, m, target)
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.Rcode != dns.RcodeSuccess:
+			lastErr = fmt.Errorf("%s", dns.RcodeToString[resp.Rcode])
+		default:
+			logger.Logger.Debugf("NOTIFY for %s (serial %d) acknowledged by %s", zone.Name, zone.Serial, target)
+			return nil
+		}
+
+		logger.Logger.Debugf("NOTIFY for %s to %s failed (attempt %d/%d): %v", zone.Name, target, attempt, attempts, lastErr)
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("NOTIFY for %s to %s: %w", zone.Name, target, lastErr)
+}
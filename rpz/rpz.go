@@ -0,0 +1,292 @@
+package rpz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Policy is what is done with a query matching a rule.
+type Policy string
+
+const (
+	// NXDomain answers that the name does not exist.
+	NXDomain Policy = "nxdomain"
+	// NoData answers that the name has no records of the queried type.
+	NoData Policy = "nodata"
+	// Redirect answers with local data instead, such as the address of a sinkhole.
+	Redirect Policy = "redirect"
+	// Passthru answers normally, exempting the name from any further policy.
+	Passthru Policy = "passthru"
+)
+
+// DefaultTTL is the TTL of sinkhole addresses.
+const DefaultTTL = 300
+
+// ParsePolicy parses a policy name.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case NXDomain, NoData, Redirect, Passthru:
+		return p, nil
+	}
+	return "", fmt.Errorf("unsupported policy %q", s)
+}
+
+// Rule is the policy applied to a name.
+type Rule struct {
+	Policy Policy
+	// Data holds the records answered by Redirect rules. Their owner names are
+	// replaced with the query name.
+	Data []dns.RR
+}
+
+// Answer returns the records of a Redirect rule answering q: a CNAME when the
+// rule has one, the records of the queried type otherwise.
+func (r Rule) Answer(q dns.Question) []dns.RR {
+	var out []dns.RR
+	for _, rr := range r.Data {
+		t := rr.Header().Rrtype
+		if t != dns.TypeCNAME && t != q.Qtype && q.Qtype != dns.TypeANY {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = q.Name
+		if t == dns.TypeCNAME {
+			return []dns.RR{rr}
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// Sinkhole returns the A and AAAA records of addrs, to redirect names to.
+func Sinkhole(addrs []string) ([]dns.RR, error) {
+	var out []dns.RR
+	for _, s := range addrs {
+		a, err := netip.ParseAddr(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sinkhole address %q: %w", s, err)
+		}
+		a = a.Unmap()
+		hdr := dns.RR_Header{Name: ".", Class: dns.ClassINET, Ttl: DefaultTTL}
+		if a.Is4() {
+			hdr.Rrtype = dns.TypeA
+			out = append(out, &dns.A{Hdr: hdr, A: a.AsSlice()})
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			out = append(out, &dns.AAAA{Hdr: hdr, AAAA: a.AsSlice()})
+		}
+	}
+	return out, nil
+}
+
+// Set maps names to rules. Exact names take precedence over wildcards
+// (*.example.com, matching names below example.com only), and the most
+// specific wildcard wins.
+type Set struct {
+	exact map[string]Rule
+	// wildcard is keyed by the parent of the wildcard label.
+	wildcard map[string]Rule
+}
+
+// NewSet returns an empty set.
+func NewSet() *Set {
+	return &Set{exact: map[string]Rule{}, wildcard: map[string]Rule{}}
+}
+
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Add sets the rule of name, a wildcard when it starts with "*.".
+func (s *Set) Add(name string, r Rule) {
+	name = canonical(name)
+	if parent, ok := strings.CutPrefix(name, "*."); ok {
+		s.wildcard[parent] = r
+		return
+	}
+	s.exact[name] = r
+}
+
+// Len returns the number of names and wildcards in the set.
+func (s *Set) Len() int {
+	return len(s.exact) + len(s.wildcard)
+}
+
+// Match returns the rule applying to name.
+func (s *Set) Match(name string) (Rule, bool) {
+	name = canonical(name)
+	if r, ok := s.exact[name]; ok {
+		return r, true
+	}
+	for {
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok {
+			return Rule{}, false
+		}
+		if r, ok := s.wildcard[parent]; ok {
+			return r, true
+		}
+		name = parent
+	}
+}
+
+// Override replaces the rule of every name in the set with r.
+func (s *Set) Override(r Rule) {
+	for name := range s.exact {
+		s.exact[name] = r
+	}
+	for name := range s.wildcard {
+		s.wildcard[name] = r
+	}
+}
+
+// ParseHosts reads a hosts file ("address name..." lines, # comments) and
+// applies rule to every name in it. A Redirect rule without data redirects
+// the names to the address they are listed with, unless it is unspecified
+// (0.0.0.0 or ::) or a loopback address, which blocklists use as a sink.
+func ParseHosts(r io.Reader, rule Rule) (*Set, error) {
+	s := NewSet()
+	err := scanLines(r, func(fields []string) error {
+		if len(fields) < 2 {
+			return fmt.Errorf("missing name")
+		}
+		a, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid address %q", fields[0])
+		}
+		lr := rule
+		if lr.Policy == Redirect && len(lr.Data) == 0 {
+			if a.IsUnspecified() || a.IsLoopback() {
+				lr.Policy = NXDomain
+			} else if lr.Data, err = Sinkhole([]string{a.String()}); err != nil {
+				return err
+			}
+		}
+		for _, name := range fields[1:] {
+			if _, ok := dns.IsDomainName(name); !ok {
+				return fmt.Errorf("invalid name %q", name)
+			}
+			// Hosts files list the local host names and addresses too
+			if _, err := netip.ParseAddr(name); err == nil {
+				continue
+			}
+			switch canonical(name) {
+			case "localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback":
+				continue
+			}
+			s.Add(name, lr)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// ParseDomains reads a list of domains, one per line with # comments, and
+// applies rule to each of them and every name below them.
+func ParseDomains(r io.Reader, rule Rule) (*Set, error) {
+	s := NewSet()
+	err := scanLines(r, func(fields []string) error {
+		name := strings.TrimPrefix(fields[0], "*.")
+		if _, ok := dns.IsDomainName(name); !ok || len(fields) > 1 {
+			return fmt.Errorf("invalid domain %q", strings.Join(fields, " "))
+		}
+		s.Add(name, rule)
+		s.Add("*."+name, rule)
+		return nil
+	})
+	return s, err
+}
+
+func scanLines(r io.Reader, line func(fields []string) error) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := line(fields); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// ReadZone parses an RPZ zone file with the given origin.
+func ReadZone(r io.Reader, origin, file string) ([]dns.RR, error) {
+	zp := dns.NewZoneParser(r, dns.Fqdn(origin), file)
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	return rrs, zp.Err()
+}
+
+// FromZone builds the rules of a response policy zone from its records.
+// Only QNAME triggers are supported; records of other triggers (rpz-ip,
+// rpz-nsdname, ...) and unsupported actions are skipped and counted.
+func FromZone(origin string, rrs []dns.RR) (s *Set, skipped int) {
+	origin = canonical(origin)
+	policies := map[string]Policy{}
+	data := map[string][]dns.RR{}
+	var order []string
+
+	for _, rr := range rrs {
+		owner := canonical(rr.Header().Name)
+		trigger, ok := strings.CutSuffix(owner, "."+origin)
+		if !ok {
+			// The apex SOA and NS records carry no policy
+			if owner != origin {
+				skipped++
+			}
+			continue
+		}
+		labels := dns.SplitDomainName(trigger)
+		if strings.HasPrefix(labels[len(labels)-1], "rpz-") {
+			skipped++
+			continue
+		}
+
+		if _, seen := policies[trigger]; !seen {
+			if _, seen := data[trigger]; !seen {
+				order = append(order, trigger)
+			}
+		}
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			data[trigger] = append(data[trigger], rr)
+			continue
+		}
+		switch target := canonical(cname.Target); {
+		case target == "":
+			policies[trigger] = NXDomain
+		case target == "*":
+			policies[trigger] = NoData
+		case target == "rpz-passthru", target == trigger:
+			policies[trigger] = Passthru
+		case strings.HasPrefix(target, "rpz-"):
+			// rpz-drop and rpz-tcp-only
+			policies[trigger] = ""
+		default:
+			data[trigger] = append(data[trigger], rr)
+		}
+	}
+
+	s = NewSet()
+	for _, trigger := range order {
+		switch p, special := policies[trigger]; {
+		case special && p == "":
+			skipped++
+		case special:
+			s.Add(trigger, Rule{Policy: p})
+		default:
+			s.Add(trigger, Rule{Policy: Redirect, Data: data[trigger]})
+		}
+	}
+	return s, skipped
+}
@@ -0,0 +1,213 @@
+package rpz
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = `
+$TTL 300
+@                      SOA   ns.rpz.test. hostmaster.rpz.test. 1 3600 600 86400 300
+                       NS    ns.rpz.test.
+blocked.example        CNAME .
+*.blocked.example      CNAME .
+empty.example          CNAME *.
+allowed.example        CNAME rpz-passthru.
+self.example           CNAME self.example.
+walled.example         CNAME walled-garden.test.
+sinkhole.example       A     192.0.2.1
+sinkhole.example       AAAA  2001:db8::1
+dropped.example        CNAME rpz-drop.
+32.1.2.0.192.rpz-ip    CNAME .
+`
+
+func readTestZone(t *testing.T) []dns.RR {
+	t.Helper()
+	rrs, err := ReadZone(strings.NewReader(testZone), "rpz.test", "rpz.test.zone")
+	if err != nil {
+		t.Fatalf("failed to read zone: %v", err)
+	}
+	return rrs
+}
+
+func TestFromZone(t *testing.T) {
+	s, skipped := FromZone("rpz.test.", readTestZone(t))
+
+	// rpz-drop and the rpz-ip trigger
+	if skipped != 2 {
+		t.Errorf("skipped %d records, want 2", skipped)
+	}
+	if s.Len() != 7 {
+		t.Errorf("set has %d entries, want 7", s.Len())
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"blocked.example.", NXDomain},
+		{"www.blocked.example.", NXDomain},
+		{"empty.example.", NoData},
+		{"allowed.example.", Passthru},
+		{"self.example.", Passthru},
+		{"walled.example.", Redirect},
+		{"sinkhole.example.", Redirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := s.Match(tt.name)
+			if !ok {
+				t.Fatal("no match")
+			}
+			if r.Policy != tt.policy {
+				t.Errorf("policy %q, want %q", r.Policy, tt.policy)
+			}
+		})
+	}
+
+	for _, name := range []string{"dropped.example.", "www.empty.example.", "rpz.test."} {
+		if r, ok := s.Match(name); ok {
+			t.Errorf("%s matched %q, want no match", name, r.Policy)
+		}
+	}
+}
+
+func TestFromZoneRedirect(t *testing.T) {
+	s, _ := FromZone("rpz.test", readTestZone(t))
+
+	r, _ := s.Match("walled.example.")
+	ans := r.Answer(dns.Question{Name: "Walled.Example.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if len(ans) != 1 {
+		t.Fatalf("got %d answers, want the CNAME only", len(ans))
+	}
+	cname, ok := ans[0].(*dns.CNAME)
+	if !ok || cname.Target != "walled-garden.test." || cname.Hdr.Name != "Walled.Example." {
+		t.Errorf("got %v, want a CNAME to walled-garden.test. owned by the query name", ans[0])
+	}
+
+	r, _ = s.Match("sinkhole.example.")
+	ans = r.Answer(dns.Question{Name: "sinkhole.example.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	if len(ans) != 1 || ans[0].Header().Rrtype != dns.TypeAAAA {
+		t.Errorf("got %v, want the AAAA record", ans)
+	}
+	if ans := r.Answer(dns.Question{Name: "sinkhole.example.", Qtype: dns.TypeMX, Qclass: dns.ClassINET}); len(ans) != 0 {
+		t.Errorf("got %v for MX, want no records", ans)
+	}
+}
+
+const testHosts = `
+# Blocklist
+127.0.0.1   localhost
+::1         ip6-localhost ip6-loopback
+0.0.0.0     0.0.0.0
+0.0.0.0     ads.example tracker.example   # trailing comment
+127.0.0.1   loopback.example
+192.0.2.10  sinkholed.example
+`
+
+func TestParseHosts(t *testing.T) {
+	s, err := ParseHosts(strings.NewReader(testHosts), Rule{Policy: Redirect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 4 {
+		t.Errorf("set has %d entries, want 4", s.Len())
+	}
+
+	for _, name := range []string{"localhost", "ip6-loopback", "0.0.0.0", "www.ads.example"} {
+		if _, ok := s.Match(name); ok {
+			t.Errorf("%s matched, want no match", name)
+		}
+	}
+	for _, name := range []string{"ads.example", "tracker.example", "loopback.example"} {
+		if r, _ := s.Match(name); r.Policy != NXDomain {
+			t.Errorf("%s: policy %q, want nxdomain", name, r.Policy)
+		}
+	}
+
+	r, _ := s.Match("sinkholed.example.")
+	ans := r.Answer(dns.Question{Name: "sinkholed.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if r.Policy != Redirect || len(ans) != 1 || ans[0].(*dns.A).A.String() != "192.0.2.10" {
+		t.Errorf("got %q %v, want a redirect to 192.0.2.10", r.Policy, ans)
+	}
+}
+
+func TestParseHostsSinkhole(t *testing.T) {
+	data, err := Sinkhole([]string{"198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseHosts(strings.NewReader(testHosts), Rule{Policy: Redirect, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A configured sinkhole replaces the listed addresses
+	for _, name := range []string{"ads.example", "sinkholed.example"} {
+		r, _ := s.Match(name)
+		ans := r.Answer(dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		if r.Policy != Redirect || len(ans) != 1 || ans[0].(*dns.A).A.String() != "198.51.100.1" {
+			t.Errorf("%s: got %q %v, want a redirect to 198.51.100.1", name, r.Policy, ans)
+		}
+	}
+}
+
+func TestParseHostsErrors(t *testing.T) {
+	for _, in := range []string{"0.0.0.0", "not-an-address example.com", "0.0.0.0 bad..name"} {
+		if _, err := ParseHosts(strings.NewReader(in), Rule{Policy: NXDomain}); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestParseDomains(t *testing.T) {
+	s, err := ParseDomains(strings.NewReader("# Blocklist\nexample.com\n*.Example.NET\n"), Rule{Policy: NoData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"example.com.", "a.b.example.com", "example.net", "WWW.example.net."} {
+		if r, ok := s.Match(name); !ok || r.Policy != NoData {
+			t.Errorf("%s: got %q, want nodata", name, r.Policy)
+		}
+	}
+	for _, name := range []string{"com", "notexample.com", "example.org"} {
+		if _, ok := s.Match(name); ok {
+			t.Errorf("%s matched, want no match", name)
+		}
+	}
+
+	if _, err := ParseDomains(strings.NewReader("example.com example.net\n"), Rule{Policy: NoData}); err == nil {
+		t.Error("expected an error for a line with several names")
+	}
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	s := NewSet()
+	s.Add("*.example.com", Rule{Policy: NXDomain})
+	s.Add("*.sub.example.com", Rule{Policy: NoData})
+	s.Add("www.sub.example.com", Rule{Policy: Passthru})
+
+	tests := map[string]Policy{
+		"www.sub.example.com": Passthru,
+		"a.sub.example.com":   NoData,
+		"a.b.sub.example.com": NoData,
+		"sub.example.com":     NXDomain,
+		"a.example.com":       NXDomain,
+	}
+	for name, want := range tests {
+		if r, _ := s.Match(name); r.Policy != want {
+			t.Errorf("%s: policy %q, want %q", name, r.Policy, want)
+		}
+	}
+	if _, ok := s.Match("example.com"); ok {
+		t.Error("wildcard matched its parent name")
+	}
+
+	s.Override(Rule{Policy: Passthru})
+	if r, _ := s.Match("a.example.com"); r.Policy != Passthru {
+		t.Errorf("policy %q after override, want passthru", r.Policy)
+	}
+}
@@ -0,0 +1,127 @@
+package rrl
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Action is what to do with a response.
+type Action int
+
+const (
+	// Send the response as usual.
+	Send Action = iota
+	// Drop the response.
+	Drop
+	// Slip sends an empty truncated response instead, so legitimate clients
+	// behind a spoofed prefix can still get their answer over TCP.
+	Slip
+)
+
+func (a Action) String() string {
+	switch a {
+	case Drop:
+		return "drop"
+	case Slip:
+		return "slip"
+	}
+	return "send"
+}
+
+// idleTimeout is how long an untouched bucket is kept.
+const idleTimeout = time.Minute
+
+// Config configures a Limiter.
+type Config struct {
+	// ResponsesPerSecond is the rate of identical responses allowed per client prefix.
+	ResponsesPerSecond int
+	// Slip sends every Slip-th limited response truncated instead of dropping
+	// it; 0 drops all of them.
+	Slip int
+	// IPv4PrefixLen and IPv6PrefixLen group clients into networks.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// Limiter implements Response Rate Limiting: responses are accounted to token
+// buckets per client network and response token (e.g. qname/qtype/rcode).
+type Limiter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	buckets map[key]*bucket
+	swept   time.Time
+}
+
+type key struct {
+	network netip.Prefix
+	token   string
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// limited counts responses limited since the bucket last had tokens.
+	limited int
+}
+
+// New returns a limiter for cfg.
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, buckets: map[key]*bucket{}, swept: time.Now()}
+}
+
+// Network returns the client network addr is accounted to.
+func (l *Limiter) Network(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	bits := l.cfg.IPv6PrefixLen
+	if addr.Is4() {
+		bits = l.cfg.IPv4PrefixLen
+	}
+	p, _ := addr.Prefix(bits)
+	return p
+}
+
+// Check accounts a response with the given token to the network of addr and
+// returns what to do with it. first reports the first limited response of a
+// burst, for logging.
+func (l *Limiter) Check(addr netip.Addr, token string, now time.Time) (action Action, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > idleTimeout {
+		l.sweep(now)
+	}
+
+	rate := float64(l.cfg.ResponsesPerSecond)
+	k := key{network: l.Network(addr), token: token}
+	b := l.buckets[k]
+	if b == nil {
+		b = &bucket{tokens: rate, updated: now}
+		l.buckets[k] = b
+	}
+	b.tokens = min(rate, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = 0
+		return Send, false
+	}
+
+	b.limited++
+	if l.cfg.Slip > 0 && b.limited%l.cfg.Slip == 0 {
+		return Slip, b.limited == 1
+	}
+	return Drop, b.limited == 1
+}
+
+// sweep forgets buckets that have been idle long enough to be full again.
+func (l *Limiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if now.Sub(b.updated) > idleTimeout {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,129 @@
+package rrl
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testConfig(slip int) Config {
+	return Config{ResponsesPerSecond: 5, Slip: slip, IPv4PrefixLen: 24, IPv6PrefixLen: 56}
+}
+
+// burst checks n responses for addr and token at now.
+func burst(l *Limiter, addr netip.Addr, token string, now time.Time, n int) (actions []Action, firsts int) {
+	for range n {
+		a, first := l.Check(addr, token, now)
+		actions = append(actions, a)
+		if first {
+			firsts++
+		}
+	}
+	return actions, firsts
+}
+
+func TestCheckSlip(t *testing.T) {
+	l := New(testConfig(2))
+	now := time.Now()
+	client := netip.MustParseAddr("192.0.2.1")
+
+	got, firsts := burst(l, client, "example.com./A/NOERROR", now, 10)
+	want := []Action{Send, Send, Send, Send, Send, Drop, Slip, Drop, Slip, Drop}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if firsts != 1 {
+		t.Errorf("first reported %d times, want once per burst", firsts)
+	}
+}
+
+func TestCheckSlipZeroDropsAll(t *testing.T) {
+	l := New(testConfig(0))
+	now := time.Now()
+
+	got, _ := burst(l, netip.MustParseAddr("192.0.2.1"), "example.com./A/NOERROR", now, 10)
+	for i, a := range got[5:] {
+		if a != Drop {
+			t.Errorf("limited response %d: got %v, want drop", i, a)
+		}
+	}
+}
+
+func TestCheckNetworks(t *testing.T) {
+	l := New(testConfig(2))
+	now := time.Now()
+	token := "example.com./A/NOERROR"
+	burst(l, netip.MustParseAddr("192.0.2.1"), token, now, 5)
+
+	tests := []struct {
+		name    string
+		addr    string
+		token   string
+		limited bool
+	}{
+		{"same /24", "192.0.2.200", token, true},
+		{"mapped address in same /24", "::ffff:192.0.2.7", token, true},
+		{"other /24", "192.0.3.1", token, false},
+		{"other token", "192.0.2.1", "example.com./AAAA/NOERROR", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := l.Check(netip.MustParseAddr(tt.addr), tt.token, now)
+			if limited := got != Send; limited != tt.limited {
+				t.Errorf("got %v, want limited %t", got, tt.limited)
+			}
+		})
+	}
+}
+
+func TestCheckIPv6Network(t *testing.T) {
+	l := New(testConfig(2))
+	now := time.Now()
+	token := "example.com./A/NOERROR"
+	burst(l, netip.MustParseAddr("2001:db8:0:1::1"), token, now, 5)
+
+	if got, _ := l.Check(netip.MustParseAddr("2001:db8:0:ff::1"), token, now); got != Drop {
+		t.Errorf("same /56: got %v, want drop", got)
+	}
+	if got, _ := l.Check(netip.MustParseAddr("2001:db8:0:100::1"), token, now); got != Send {
+		t.Errorf("other /56: got %v, want send", got)
+	}
+}
+
+func TestCheckRefill(t *testing.T) {
+	l := New(testConfig(2))
+	now := time.Now()
+	client := netip.MustParseAddr("192.0.2.1")
+	token := "example.com./A/NOERROR"
+
+	if got, _ := burst(l, client, token, now, 6); got[5] != Drop {
+		t.Fatalf("got %v, want the sixth response dropped", got)
+	}
+
+	// A fifth of a second refills one token
+	if got, _ := l.Check(client, token, now.Add(200*time.Millisecond)); got != Send {
+		t.Errorf("after 200ms: got %v, want send", got)
+	}
+
+	// A full second refills the bucket and starts a new burst
+	got, firsts := burst(l, client, token, now.Add(1200*time.Millisecond), 7)
+	want := []Action{Send, Send, Send, Send, Send, Drop, Slip}
+	if !slices.Equal(got, want) {
+		t.Errorf("after refill: got %v, want %v", got, want)
+	}
+	if firsts != 1 {
+		t.Errorf("first reported %d times in the new burst, want once", firsts)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(testConfig(2))
+	now := time.Now()
+	burst(l, netip.MustParseAddr("192.0.2.1"), "example.com./A/NOERROR", now, 5)
+
+	l.Check(netip.MustParseAddr("198.51.100.1"), "example.com./A/NOERROR", now.Add(2*idleTimeout))
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("%d buckets after sweep, want 1", n)
+	}
+}
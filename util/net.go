@@ -0,0 +1,50 @@
+package util
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// AddrOf extracts the IP address of a net.Addr such as a DNS client's remote address.
+func AddrOf(addr net.Addr) netip.Addr {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+			return ap.Addr().Unmap()
+		}
+		return netip.Addr{}
+	}
+	a, _ := netip.AddrFromSlice(ip)
+	return a.Unmap()
+}
+
+// ParsePrefix parses a CIDR, accepting bare addresses as single host prefixes.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	return p.Masked(), err
+}
+
+// PrefixesContain reports whether ip lies in any of the given CIDRs.
+// Entries that cannot be parsed are ignored.
+func PrefixesContain(cidrs []string, ip netip.Addr) bool {
+	for _, c := range cidrs {
+		if p, err := ParsePrefix(c); err == nil && p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}